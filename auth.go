@@ -0,0 +1,231 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+/*
+From RFC 5531:
+    The call message has two authentication fields: the credential and
+    the verifier.  The reply message has one authentication field: the
+    response verifier.
+*/
+
+// Size of an OpaqueAuth with empty body when marshalled: flavor followed by
+// length of the body.
+const emptyOpaqueAuthSize = 8
+
+// opaqueAuthSize returns the size of auth when marshalled.
+func opaqueAuthSize(auth OpaqueAuth) int {
+	return emptyOpaqueAuthSize + (len(auth.Body)+3)&^3
+}
+
+/*
+From RFC 5531:
+    The credential of AUTH_SYS ... is:
+
+         struct authsys_parms {
+            unsigned int stamp;
+            string machinename<255>;
+            unsigned int uid;
+            unsigned int gid;
+            unsigned int gids<16>;
+         };
+*/
+
+// AuthSysParms is the Unix style identity of a caller, as carried by the
+// credential of the AUTH_SYS flavor.
+type AuthSysParms struct {
+	Stamp       uint32
+	MachineName string
+	UID         uint32
+	GID         uint32
+	GIDs        []uint32
+}
+
+// Auth authenticates the calls made by a client using an authentication
+// flavor. Pass it to NewClientCodec using WithAuth. Clients use AUTH_NONE
+// by default.
+type Auth interface {
+	// NewCall returns the authentication of the next call.
+	NewCall() (AuthCall, error)
+}
+
+// AuthCall authenticates a single call and its reply.
+type AuthCall interface {
+	// Credential returns the credential of the call.
+	Credential() OpaqueAuth
+	// Verifier returns the verifier of the call given the header of the
+	// call up to and including the credential.
+	Verifier(header []byte) (OpaqueAuth, error)
+	// ValidateReply checks the verifier of the reply to the call.
+	ValidateReply(verf OpaqueAuth) error
+	// Refresh is called when the server rejects the identity of the
+	// caller for the reason specified. It returns true if the credential
+	// was refreshed, in which case the call is made once more, with a new
+	// AuthCall.
+	Refresh(stat AuthStat) bool
+}
+
+// authWrapper is implemented by the authentication of calls which also
+// protects their args and results.
+type authWrapper interface {
+	// wrapArgs protects the marshalled args of the call.
+	wrapArgs(args []byte) ([]byte, error)
+	// unwrapResult returns the marshalled result from the protected one.
+	unwrapResult(result []byte) ([]byte, error)
+}
+
+// authDestroyer is implemented by client authentications which have state
+// on the server to be destroyed when the client is closed.
+type authDestroyer interface {
+	// destroyCall returns the call message which destroys the state.
+	destroyCall() ([]byte, error)
+}
+
+// authRefresher is implemented by client authentications whose state on the
+// server is created again by calls made on the connection, once a call was
+// refreshed.
+type authRefresher interface {
+	// refresh creates the state again if needed, making the calls to the
+	// server at addr using exchange.
+	refresh(exchange authExchange, addr net.Addr) error
+}
+
+// authExchange makes the call with the xid specified and returns the record
+// of its reply.
+type authExchange func(xid uint32, payload []byte) ([]byte, error)
+
+// WithAuth makes the client authenticate calls using auth.
+func WithAuth(auth Auth) ClientOption {
+	return func(c *clientCodec) {
+		c.auth = auth
+	}
+}
+
+// noneAuth is the AUTH_NONE flavor, with which calls carry no identity.
+type noneAuth struct{}
+
+func (noneAuth) NewCall() (AuthCall, error) {
+	return noneAuth{}, nil
+}
+
+func (noneAuth) Credential() OpaqueAuth {
+	return OpaqueAuth{Flavor: AuthNone}
+}
+
+func (noneAuth) Verifier(header []byte) (OpaqueAuth, error) {
+	return OpaqueAuth{Flavor: AuthNone}, nil
+}
+
+func (noneAuth) ValidateReply(verf OpaqueAuth) error {
+	return nil
+}
+
+func (noneAuth) Refresh(stat AuthStat) bool {
+	return false
+}
+
+// unwrapReply validates the verifier of a successful reply and returns the
+// reader of the marshalled result, read from body.
+func unwrapReply(reply *RPCMsg, body io.Reader, auth AuthCall) (io.Reader, error) {
+
+	if err := auth.ValidateReply(reply.RBody.Areply.Verf); err != nil {
+		return nil, err
+	}
+
+	wrapper, ok := auth.(authWrapper)
+	if !ok {
+		return body, nil
+	}
+
+	result, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if result, err = wrapper.unwrapResult(result); err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(result), nil
+}
+
+// replyAuth authenticates the reply to a call on the server.
+type replyAuth interface {
+	// replyVerifier returns the verifier of the reply.
+	replyVerifier() (OpaqueAuth, error)
+	// wrapResult protects the marshalled result of the call.
+	wrapResult(result []byte) ([]byte, error)
+}
+
+// encodeAuthErrorReply returns the RPC reply message rejecting a call for
+// the authentication error specified.
+func encodeAuthErrorReply(xid uint32, stat AuthStat) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	reply := RPCMsg{
+		Xid:  xid,
+		Type: Reply,
+		RBody: ReplyBody{
+			Stat: MsgDenied,
+			Rreply: RejectedReply{
+				Stat:     AuthError,
+				AuthStat: stat,
+			},
+		},
+	}
+
+	if _, err := xdr.Marshal(&buf, reply); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// CallInfo describes a call received by a server and its caller.
+type CallInfo struct {
+	Xid         uint32
+	ProcedureID ProcedureID
+	RemoteAddr  net.Addr   // nil if the transport has no addresses
+	Flavor      AuthFlavor // flavor of the credential of the call
+
+	// Principal is the authenticated identity of the caller for the
+	// RPCSEC_GSS flavor and GSSService is the protection of the call.
+	Principal  string
+	GSSService GSSService
+
+	// TLS is the state of the connection if it was upgraded to TLS.
+	TLS *tls.ConnectionState
+
+	// Peer is the identity of the caller verified by the kernel, if the
+	// call arrived over a Unix domain socket on a platform supporting it.
+	Peer *PeerCred
+
+	// Sys is the Unix style identity of the caller verified by mapping its
+	// TLS certificate with TLSIdentityFunc. It's nil otherwise.
+	Sys *AuthSysParms
+
+	// AuthSys is the AUTH_SYS (or AUTH_SHORT) credential of the call. It's
+	// merely asserted by the caller and anyone able to reach the server can
+	// claim any identity with it, so it must not be trusted unless the
+	// caller is known by other means, such as Sys or Peer.
+	AuthSys *AuthSysParms
+}
+
+// CallInfoReceiver can be implemented by the args type of a procedure that
+// needs to know about the call being served and its caller. The server
+// codec calls SetCallInfo on the args after unmarshalling them.
+type CallInfoReceiver interface {
+	SetCallInfo(info *CallInfo)
+}
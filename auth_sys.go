@@ -0,0 +1,266 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"sync"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+/*
+From RFC 5531:
+    The verifier accompanying the credential should have "AUTH_NONE"
+    flavor value (defined above).  Note that this credential is only
+    unique within a particular domain of machine names, uids, and gids.
+
+    The flavor value of the verifier received in the reply message from
+    the server may be "AUTH_NONE" or "AUTH_SHORT".  In the case of
+    "AUTH_SHORT", the bytes of the reply verifier's string encode an
+    opaque structure.  This new opaque structure may now be passed to the
+    server instead of the original "AUTH_SYS" flavor credential.  The
+    server may keep a cache that maps shorthand opaque structures (passed
+    back by way of an "AUTH_SHORT" style reply verifier) to the original
+    credentials of the caller.  The caller can save network bandwidth and
+    server cpu cycles by using the shorthand credential.
+
+    The server may flush the shorthand opaque structure at any time.  If
+    this happens, the remote procedure call message will be rejected due
+    to an authentication error.  The reason for the failure will be
+    AUTH_REJECTEDCRED.  At this point, the client may wish to try the
+    original "AUTH_SYS" style of credential.
+*/
+
+// Limits on the credential of AUTH_SYS
+const (
+	maxAuthSysMachineName = 255
+	maxAuthSysGIDs        = 16
+)
+
+// SysClientAuth authenticates the calls made by a client with an AUTH_SYS
+// credential. The shorthand credential returned by servers supporting
+// AUTH_SHORT is used in its place for subsequent calls; calls rejected for
+// it are made once more with the full credential. Pass it to
+// NewClientCodec using WithAuth.
+type SysClientAuth struct {
+	cred []byte // marshalled AuthSysParms
+
+	mutex sync.Mutex // protects short
+	short []byte     // shorthand credential, if any
+}
+
+// NewSysClientAuth returns a SysClientAuth which identifies the caller using
+// parms.
+func NewSysClientAuth(parms *AuthSysParms) (*SysClientAuth, error) {
+
+	if len(parms.MachineName) > maxAuthSysMachineName || len(parms.GIDs) > maxAuthSysGIDs {
+		return nil, ErrInvalidAuthSysParms
+	}
+
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, parms); err != nil {
+		return nil, err
+	}
+
+	return &SysClientAuth{cred: buf.Bytes()}, nil
+}
+
+// NewCall returns the authentication of the next call.
+func (a *SysClientAuth) NewCall() (AuthCall, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return &authSysCall{auth: a, short: a.short}, nil
+}
+
+// authSysCall is the authentication of a single call made using
+// SysClientAuth.
+type authSysCall struct {
+	auth  *SysClientAuth
+	short []byte // shorthand credential used for the call, if any
+}
+
+func (c *authSysCall) Credential() OpaqueAuth {
+	if c.short != nil {
+		return OpaqueAuth{Flavor: AuthShort, Body: c.short}
+	}
+	return OpaqueAuth{Flavor: AuthSys, Body: c.auth.cred}
+}
+
+func (c *authSysCall) Verifier(header []byte) (OpaqueAuth, error) {
+	return OpaqueAuth{Flavor: AuthNone}, nil
+}
+
+func (c *authSysCall) ValidateReply(verf OpaqueAuth) error {
+	if verf.Flavor == AuthShort && len(verf.Body) > 0 {
+		c.auth.mutex.Lock()
+		c.auth.short = verf.Body
+		c.auth.mutex.Unlock()
+	}
+	return nil
+}
+
+// Refresh drops the shorthand credential if the server flushed it, so that
+// the call is made again with the full credential.
+func (c *authSysCall) Refresh(stat AuthStat) bool {
+	if c.short == nil || (stat != AuthRejectedcred && stat != AuthBadcred) {
+		return false
+	}
+
+	c.auth.mutex.Lock()
+	if bytes.Equal(c.auth.short, c.short) {
+		c.auth.short = nil
+	}
+	c.auth.mutex.Unlock()
+
+	c.short = nil
+	return true
+}
+
+// AuthShortCache maps the shorthand credentials handed out to clients using
+// AUTH_SYS to their full credentials. A cache is shared by the server codecs
+// of all connections to which it is passed using WithAuthShort. The least
+// recently used credentials are flushed once the cache is full.
+type AuthShortCache struct {
+	size int
+
+	mutex    sync.Mutex
+	lru      *list.List               // of *authShortEntry, most recent first
+	byHandle map[string]*list.Element // by shorthand credential
+	byCred   map[string]*list.Element // by full credential
+}
+
+type authShortEntry struct {
+	handle string
+	cred   string
+	parms  *AuthSysParms
+}
+
+// Default number of credentials held by an AuthShortCache
+const defaultAuthShortCacheSize = 1024
+
+// Size of the shorthand credentials, which are random so that they can't be
+// guessed by other clients.
+const authShortHandleSize = 16
+
+// NewAuthShortCache returns a cache holding up to size credentials. If size
+// is 0, a default of 1024 is used.
+func NewAuthShortCache(size int) *AuthShortCache {
+	if size <= 0 {
+		size = defaultAuthShortCacheSize
+	}
+	return &AuthShortCache{
+		size:     size,
+		lru:      list.New(),
+		byHandle: make(map[string]*list.Element),
+		byCred:   make(map[string]*list.Element),
+	}
+}
+
+// WithAuthShort makes the server codec return shorthand credentials to
+// clients using AUTH_SYS, and accept them thereafter, using cache.
+func WithAuthShort(cache *AuthShortCache) ServerOption {
+	return func(c *serverCodec) {
+		c.authShort = cache
+	}
+}
+
+// add returns the shorthand credential for the full credential cred.
+func (c *AuthShortCache) add(cred []byte, parms *AuthSysParms) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.byCred[string(cred)]; ok {
+		c.lru.MoveToFront(e)
+		return []byte(e.Value.(*authShortEntry).handle), nil
+	}
+
+	handle := make([]byte, authShortHandleSize)
+	for {
+		if _, err := rand.Read(handle); err != nil {
+			return nil, err
+		}
+		if _, ok := c.byHandle[string(handle)]; !ok {
+			break
+		}
+	}
+
+	entry := &authShortEntry{string(handle), string(cred), parms}
+	e := c.lru.PushFront(entry)
+	c.byHandle[entry.handle] = e
+	c.byCred[entry.cred] = e
+
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Remove(c.lru.Back()).(*authShortEntry)
+		delete(c.byHandle, oldest.handle)
+		delete(c.byCred, oldest.cred)
+	}
+
+	return handle, nil
+}
+
+// get returns the full credential for the shorthand credential handle.
+func (c *AuthShortCache) get(handle []byte) (*AuthSysParms, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.byHandle[string(handle)]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*authShortEntry).parms, true
+}
+
+// Flush forgets all credentials. Clients then fall back to their full
+// credentials.
+func (c *AuthShortCache) Flush() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lru.Init()
+	c.byHandle = make(map[string]*list.Element)
+	c.byCred = make(map[string]*list.Element)
+}
+
+// acceptSys authenticates a call with an AUTH_SYS or AUTH_SHORT credential
+// and sets the credential of the call info. AuthOk is returned if the call is
+// accepted, along with the authentication of the reply, if any.
+func (c *serverCodec) acceptSys(cred OpaqueAuth) (replyAuth, AuthStat) {
+
+	var parms *AuthSysParms
+	var auth replyAuth
+
+	if cred.Flavor == AuthShort {
+		if c.authShort == nil {
+			return nil, AuthBadcred
+		}
+		var ok bool
+		if parms, ok = c.authShort.get(cred.Body); !ok {
+			return nil, AuthRejectedcred
+		}
+	} else {
+		parms = new(AuthSysParms)
+		if _, err := xdr.Unmarshal(bytes.NewReader(cred.Body), parms); err != nil {
+			return nil, AuthBadcred
+		}
+		if len(parms.MachineName) > maxAuthSysMachineName || len(parms.GIDs) > maxAuthSysGIDs {
+			return nil, AuthBadcred
+		}
+		if c.authShort != nil {
+			// The full credential is used until a shorthand is issued
+			if handle, err := c.authShort.add(cred.Body, parms); err == nil {
+				auth = staticReplyAuth{Flavor: AuthShort, Body: handle}
+			}
+		}
+	}
+
+	c.callInfo.AuthSys = parms
+
+	return auth, AuthOk
+}
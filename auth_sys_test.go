@@ -0,0 +1,143 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+var sysTestProc = ProcedureID{ProgramNumber: 66626, ProgramVersion: 1, ProcedureNumber: 1}
+
+type SysTestArgs struct {
+	info *CallInfo
+}
+
+func (a *SysTestArgs) SetCallInfo(info *CallInfo) { a.info = info }
+
+// SysTest records the flavor of the credential of each call.
+type SysTest struct {
+	mutex   sync.Mutex
+	flavors []AuthFlavor
+}
+
+// Who returns the UID of the caller.
+func (s *SysTest) Who(args *SysTestArgs, reply *uint32) error {
+	s.mutex.Lock()
+	s.flavors = append(s.flavors, args.info.Flavor)
+	s.mutex.Unlock()
+
+	if args.info.AuthSys != nil {
+		*reply = args.info.AuthSys.UID
+	}
+	return nil
+}
+
+// serveSys serves s on one end of a pipe, handing out shorthand credentials
+// using cache, and returns the other end.
+func serveSys(t *testing.T, s *SysTest, cache *AuthShortCache) net.Conn {
+	t.Helper()
+
+	if err := RegisterProcedure(Procedure{sysTestProc, "SysTest.Who"}, true); err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.Register(s); err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(serverConn, nil, WithAuthShort(cache)))
+	t.Cleanup(func() { clientConn.Close() })
+
+	return clientConn
+}
+
+func TestAuthShort(t *testing.T) {
+	s := &SysTest{}
+	cache := NewAuthShortCache(0)
+	conn := serveSys(t, s, cache)
+
+	auth, err := NewSysClientAuth(&AuthSysParms{MachineName: "box", UID: 42, GID: 7, GIDs: []uint32{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClientWithCodec(NewClientCodec(conn, nil, WithAuth(auth)))
+	defer client.Close()
+
+	call := func() {
+		t.Helper()
+		var uid uint32
+		if err := client.Call("SysTest.Who", &SysTestArgs{}, &uid); err != nil {
+			t.Fatal(err)
+		}
+		if uid != 42 {
+			t.Fatalf("got UID %d, want 42", uid)
+		}
+	}
+
+	// The shorthand issued with the first reply is used thereafter
+	call()
+	if len(auth.short) != authShortHandleSize {
+		t.Fatalf("got shorthand %x", auth.short)
+	}
+	if _, ok := cache.get(auth.short); !ok {
+		t.Fatal("shorthand not in the cache")
+	}
+	short := auth.short
+	call()
+	call()
+	if !bytes.Equal(auth.short, short) {
+		t.Fatal("shorthand changed")
+	}
+
+	// Calls rejected for a flushed shorthand are made once more with the
+	// full credential, which gets a new shorthand.
+	cache.Flush()
+	call()
+	call()
+	if bytes.Equal(auth.short, short) {
+		t.Fatal("flushed shorthand kept")
+	}
+
+	want := []AuthFlavor{AuthSys, AuthShort, AuthShort, AuthSys, AuthShort}
+	if !reflect.DeepEqual(s.flavors, want) {
+		t.Fatalf("got flavors %v, want %v", s.flavors, want)
+	}
+}
+
+func TestAuthShortCache(t *testing.T) {
+	cache := NewAuthShortCache(2)
+
+	alice, err := cache.add([]byte("alice"), &AuthSysParms{UID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := cache.add([]byte("alice"), &AuthSysParms{UID: 1}); !bytes.Equal(again, alice) {
+		t.Fatal("credential given a second shorthand")
+	}
+	bob, _ := cache.add([]byte("bob"), &AuthSysParms{UID: 2})
+	if bytes.Equal(alice, bob) {
+		t.Fatal("credentials given the same shorthand")
+	}
+	if parms, ok := cache.get(alice); !ok || parms.UID != 1 {
+		t.Fatalf("got %v for the shorthand of alice", parms)
+	}
+
+	// The least recently used credential is flushed first
+	if _, err := cache.add([]byte("carol"), &AuthSysParms{UID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.get(bob); ok {
+		t.Fatal("least recently used credential kept")
+	}
+	if _, ok := cache.get(alice); !ok {
+		t.Fatal("recently used credential flushed")
+	}
+}
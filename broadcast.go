@@ -0,0 +1,230 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+// Initial interval after which a broadcast call is retransmitted. It is
+// doubled on each retransmission.
+const broadcastRetransmit = 1 * time.Second
+
+// broadcastAddrs returns the broadcast addresses of all IPv4 networks on
+// the interfaces which are up and support broadcast.
+func broadcastAddrs() ([]net.IP, error) {
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []net.IP
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, ifaddr := range ifaddrs {
+			ipnet, ok := ifaddr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip4 := ipnet.IP.To4()
+			if ip4 == nil || len(ipnet.Mask) != net.IPv4len {
+				continue
+			}
+			bcast := make(net.IP, net.IPv4len)
+			for i := range ip4 {
+				bcast[i] = ip4[i] | ^ipnet.Mask[i]
+			}
+			addrs = append(addrs, bcast)
+		}
+	}
+
+	if len(addrs) == 0 {
+		addrs = append(addrs, net.IPv4bcast)
+	}
+
+	return addrs, nil
+}
+
+// PmapBroadcast calls the remote procedure specified by serviceMethod, as
+// registered in the procedure registry, on all hosts reachable through
+// the broadcast address. The call is made indirectly through the
+// portmapper's CALLIT procedure over UDP, so only hosts on which the
+// program is registered for UDP reply. The address may be "host:port" or
+// just the host in which case the portmapper port is used. If address is
+// empty string, the call is broadcast on all IPv4 networks of the local
+// host.
+//
+// For every reply received, newReply is called to allocate a value into
+// which the result is unmarshalled, which is then passed to eachResult
+// along with the address of the program on the responding host. Replies
+// to retransmissions from the same host are delivered only once. If
+// eachResult returns true, PmapBroadcast stops and returns nil. The call is
+// retransmitted with an increasing interval till ctx is done or has
+// expired, at which point PmapBroadcast returns nil if any replies were
+// received and ErrTimeout otherwise. If ctx has no deadline, a deadline of
+// 25 seconds is used.
+func PmapBroadcast(ctx context.Context, address string, serviceMethod string, args interface{},
+	newReply func() interface{}, eachResult func(addr net.Addr, reply interface{}) bool) error {
+
+	registryInit.Do(initRegistry)
+
+	procedureID, ok := GetProcedureID(serviceMethod)
+	if !ok {
+		return ErrProcUnavail
+	}
+
+	var targets []*net.UDPAddr
+	if address == "" {
+		ips, err := broadcastAddrs()
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			targets = append(targets, &net.UDPAddr{IP: ip, Port: pmapPort})
+		}
+	} else {
+		target, err := net.ResolveUDPAddr("udp4", pmapAddress(address))
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultUDPTimeout)
+		defer cancel()
+	}
+
+	// Marshal the CALLIT call with the args of the remote procedure
+	var buf bytes.Buffer
+	if args != nil {
+		if _, err := xdr.Marshal(&buf, &args); err != nil {
+			return err
+		}
+	}
+	callit := &PmapCallArgs{
+		Program:   procedureID.ProgramNumber,
+		Version:   procedureID.ProgramVersion,
+		Procedure: procedureID.ProcedureNumber,
+		Args:      buf.Bytes(),
+	}
+	callitID, _ := GetProcedureID("Pmap.ProcCallIt")
+	xid := rand.Uint32()
+	datagram, err := encodeCall(xid, callitID, callit)
+	if err != nil {
+		return err
+	}
+
+	// Go sets SO_BROADCAST on all IPv4 datagram sockets
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unblock reads when ctx is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	seen := make(map[string]bool)
+	interval := broadcastRetransmit
+	resend := time.Now()
+	inbuf := make([]byte, maxRecordSize)
+	for {
+		if ctx.Err() != nil {
+			break
+		}
+
+		now := time.Now()
+		if !now.Before(resend) {
+			for _, target := range targets {
+				if _, err := conn.WriteToUDP(datagram, target); err != nil {
+					return err
+				}
+			}
+			resend = now.Add(interval)
+			interval *= 2
+			if interval > maxUDPRetransmit {
+				interval = maxUDPRetransmit
+			}
+		}
+
+		wakeup := resend
+		if deadline, _ := ctx.Deadline(); deadline.Before(wakeup) {
+			wakeup = deadline
+		}
+		if err := conn.SetReadDeadline(wakeup); err != nil {
+			return err
+		}
+
+		n, from, err := conn.ReadFromUDP(inbuf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		reader := bytes.NewReader(inbuf[:n])
+		var reply RPCMsg
+		if _, err := xdr.Unmarshal(reader, &reply); err != nil {
+			continue
+		}
+		if reply.Xid != xid || reply.Type != Reply || checkReplyForErr(&reply) != nil {
+			continue
+		}
+
+		var result PmapCallResult
+		if _, err := xdr.Unmarshal(reader, &result); err != nil {
+			continue
+		}
+
+		addr := &net.UDPAddr{IP: from.IP, Port: int(result.Port)}
+		if seen[addr.String()] {
+			continue
+		}
+
+		value := newReply()
+		if value != nil {
+			if _, err := xdr.Unmarshal(bytes.NewReader(result.Result), &value); err != nil {
+				continue
+			}
+		}
+		seen[addr.String()] = true
+
+		if eachResult(addr, value) {
+			return nil
+		}
+	}
+
+	if len(seen) == 0 {
+		return ErrTimeout
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+var broadcastTestProc = ProcedureID{ProgramNumber: 66609, ProgramVersion: 1, ProcedureNumber: 1}
+
+// serveCallIt answers every CALLIT received on a UDP socket with a reply
+// carrying each of results in turn, and returns the address of the socket.
+func serveCallIt(t *testing.T, port uint32, results ...[]byte) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var call RPCMsg
+			if _, err := xdr.Unmarshal(bytes.NewReader(buf[:n]), &call); err != nil {
+				continue
+			}
+
+			for _, result := range results {
+				var out bytes.Buffer
+				reply := RPCMsg{Xid: call.Xid, Type: Reply, RBody: ReplyBody{
+					Stat: MsgAccepted, Areply: AcceptedReply{Stat: Success}}}
+				if _, err := xdr.Marshal(&out, &reply); err != nil {
+					return
+				}
+				if _, err := xdr.Marshal(&out, &PmapCallResult{Port: port, Result: result}); err != nil {
+					return
+				}
+				if _, err := pc.WriteTo(out.Bytes(), from); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+func TestPmapBroadcastUndecodable(t *testing.T) {
+	if err := RegisterProcedure(Procedure{broadcastTestProc, "BroadcastTest.Call"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reply which fails to decode doesn't hide a later one from the
+	// same host
+	var result bytes.Buffer
+	if _, err := xdr.Marshal(&result, uint32(7)); err != nil {
+		t.Fatal(err)
+	}
+	address := serveCallIt(t, 2049, []byte{0}, result.Bytes())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var replies []uint32
+	err := PmapBroadcast(ctx, address, "BroadcastTest.Call", nil,
+		func() interface{} { return new(uint32) },
+		func(addr net.Addr, reply interface{}) bool {
+			replies = append(replies, *reply.(*uint32))
+			return true
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || replies[0] != 7 {
+		t.Fatalf("got replies %v, want [7]", replies)
+	}
+}
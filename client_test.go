@@ -0,0 +1,321 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+var replyStatTestProc = ProcedureID{ProgramNumber: 66601, ProgramVersion: 1, ProcedureNumber: 1}
+
+// serveReplies replies to every call read from one end of a pipe with body
+// and returns the other end.
+func serveReplies(t *testing.T, body ReplyBody) net.Conn {
+	t.Helper()
+
+	if err := RegisterProcedure(Procedure{replyStatTestProc, "ReplyStatTest.Call"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		defer serverConn.Close()
+		for {
+			record, err := ReadFullRecord(serverConn)
+			if err != nil {
+				return
+			}
+			var call RPCMsg
+			if _, err := xdr.Unmarshal(bytes.NewReader(record), &call); err != nil {
+				return
+			}
+
+			var buf bytes.Buffer
+			reply := RPCMsg{Xid: call.Xid, Type: Reply, RBody: body}
+			if _, err := xdr.Marshal(&buf, &reply); err != nil {
+				return
+			}
+			if _, err := WriteFullRecord(serverConn, buf.Bytes()); err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { clientConn.Close() })
+
+	return clientConn
+}
+
+func acceptedBody(stat AcceptStat) ReplyBody {
+	return ReplyBody{Stat: MsgAccepted, Areply: AcceptedReply{Stat: stat}}
+}
+
+// Each failed reply status, and the error errors.Is finds for it
+var replyStatErrors = []struct {
+	name string
+	body ReplyBody
+	err  error
+}{
+	{"prog unavail", acceptedBody(ProgUnavail), ErrProgUnavail},
+	{"proc unavail", acceptedBody(ProcUnavail), ErrProcUnavail},
+	{"garbage args", acceptedBody(GarbageArgs), ErrGarbageArgs},
+	{"system err", acceptedBody(SystemErr), ErrSystemErr},
+	{"prog mismatch", ReplyBody{Stat: MsgAccepted, Areply: AcceptedReply{
+		Stat: ProgMismatch, MismatchInfo: MismatchReply{Low: 2, High: 3}}},
+		ErrProgMismatch{Low: 2, High: 3}},
+	{"rpc mismatch", ReplyBody{Stat: MsgDenied, Rreply: RejectedReply{
+		Stat: RPCMismatch, MismatchInfo: MismatchReply{Low: 2, High: 2}}},
+		ErrRPCMismatch{Low: 2, High: 2}},
+	{"auth error", ReplyBody{Stat: MsgDenied, Rreply: RejectedReply{
+		Stat: AuthError, AuthStat: AuthTooweak}}, ErrAuthError},
+}
+
+// checkReplyStatError checks that err describes the failure of a call
+// answered with the reply body of tc.
+func checkReplyStatError(t *testing.T, err error, body ReplyBody, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+
+	var rerr *RPCError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got error %T, want *RPCError", err)
+	}
+	if rerr.ProcedureID != replyStatTestProc || rerr.ReplyStat != body.Stat {
+		t.Fatalf("got procedure %v reply stat %d", rerr.ProcedureID, rerr.ReplyStat)
+	}
+
+	switch body.Stat {
+	case MsgAccepted:
+		if rerr.AcceptStat != body.Areply.Stat {
+			t.Fatalf("got accept stat %d, want %d", rerr.AcceptStat, body.Areply.Stat)
+		}
+		var mismatch ErrProgMismatch
+		if body.Areply.Stat == ProgMismatch && (!errors.As(err, &mismatch) ||
+			rerr.Low != mismatch.Low || rerr.High != mismatch.High) {
+			t.Fatalf("got versions %d-%d, want %v", rerr.Low, rerr.High, mismatch)
+		}
+	case MsgDenied:
+		if rerr.RejectStat != body.Rreply.Stat {
+			t.Fatalf("got reject stat %d, want %d", rerr.RejectStat, body.Rreply.Stat)
+		}
+		var mismatch ErrRPCMismatch
+		if body.Rreply.Stat == RPCMismatch && (!errors.As(err, &mismatch) ||
+			rerr.Low != mismatch.Low || rerr.High != mismatch.High) {
+			t.Fatalf("got versions %d-%d, want %v", rerr.Low, rerr.High, mismatch)
+		}
+		var rejected ErrAuthRejected
+		if body.Rreply.Stat == AuthError && (!errors.As(err, &rejected) ||
+			rejected.Stat != body.Rreply.AuthStat || rerr.AuthStat != body.Rreply.AuthStat) {
+			t.Fatalf("got auth stat %d, want %d", rerr.AuthStat, body.Rreply.AuthStat)
+		}
+	}
+}
+
+func TestClientCallReplyStat(t *testing.T) {
+	for _, tc := range replyStatErrors {
+		t.Run(tc.name, func(t *testing.T) {
+			client := WrapClient(NewClient(serveReplies(t, tc.body)))
+
+			err := client.Call("ReplyStatTest.Call", nil, nil)
+			checkReplyStatError(t, err, tc.body, tc.err)
+
+			// The client remains usable
+			err = client.Call("ReplyStatTest.Call", nil, nil)
+			checkReplyStatError(t, err, tc.body, tc.err)
+		})
+	}
+}
+
+func TestClientGoReplyStat(t *testing.T) {
+	for _, tc := range replyStatErrors {
+		t.Run(tc.name, func(t *testing.T) {
+			client := WrapClient(NewClient(serveReplies(t, tc.body)))
+
+			call := <-client.Go("ReplyStatTest.Call", nil, nil, nil).Done
+			checkReplyStatError(t, call.Error, tc.body, tc.err)
+		})
+	}
+}
+
+func TestRPCClientReplyStat(t *testing.T) {
+	// Without Client, net/rpc reduces the error to its message
+	tc := replyStatErrors[0]
+	client := NewClient(serveReplies(t, tc.body))
+
+	err := client.Call("ReplyStatTest.Call", nil, nil)
+	if _, ok := err.(rpc.ServerError); !ok {
+		t.Fatalf("got error %T, want rpc.ServerError", err)
+	}
+	if !strings.HasPrefix(err.Error(), tc.err.Error()) {
+		t.Fatalf("got error %q, want %q", err, tc.err)
+	}
+
+	// Only the call failed, not the client
+	if err := client.Call("ReplyStatTest.Call", nil, nil); err == rpc.ErrShutdown {
+		t.Fatal("client shut down")
+	}
+}
+
+func TestClientUDPTimeout(t *testing.T) {
+	if err := RegisterProcedure(Procedure{replyStatTestProc, "ReplyStatTest.Call"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing replies to calls
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := WrapClient(NewUDPClient(conn, 100*time.Millisecond, 20*time.Millisecond))
+	defer client.Close()
+
+	if err := client.Call("ReplyStatTest.Call", nil, nil); err != ErrTimeout {
+		t.Fatalf("got error %v, want %v", err, ErrTimeout)
+	}
+}
+
+// refreshTestAuth makes calls with a credential carrying the number of
+// times it was refreshed, which it always is.
+type refreshTestAuth struct {
+	mutex     sync.Mutex
+	refreshes int
+}
+
+func (a *refreshTestAuth) NewCall() (AuthCall, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return &refreshTestCall{a, a.refreshes}, nil
+}
+
+type refreshTestCall struct {
+	auth      *refreshTestAuth
+	refreshes int
+}
+
+func (c *refreshTestCall) Credential() OpaqueAuth {
+	return OpaqueAuth{Flavor: AuthSys, Body: []byte{byte(c.refreshes)}}
+}
+
+func (c *refreshTestCall) Verifier(header []byte) (OpaqueAuth, error) {
+	return OpaqueAuth{Flavor: AuthNone}, nil
+}
+
+func (c *refreshTestCall) ValidateReply(verf OpaqueAuth) error {
+	return nil
+}
+
+func (c *refreshTestCall) Refresh(stat AuthStat) bool {
+	c.auth.mutex.Lock()
+	defer c.auth.mutex.Unlock()
+	c.auth.refreshes++
+	return true
+}
+
+// serveRejecting rejects the first n calls read from one end of a pipe with
+// stat and accepts the others. It returns the other end and a function
+// returning the credentials of the calls read so far.
+func serveRejecting(t *testing.T, n int, stat AuthStat) (net.Conn, func() []byte) {
+	t.Helper()
+
+	if err := RegisterProcedure(Procedure{replyStatTestProc, "ReplyStatTest.Call"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	var creds []byte
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		defer serverConn.Close()
+		for {
+			record, err := ReadFullRecord(serverConn)
+			if err != nil {
+				return
+			}
+			var call RPCMsg
+			if _, err := xdr.Unmarshal(bytes.NewReader(record), &call); err != nil {
+				return
+			}
+			mutex.Lock()
+			creds = append(creds, call.CBody.Cred.Body...)
+			rejected := len(creds) <= n
+			mutex.Unlock()
+
+			var reply []byte
+			if rejected {
+				reply, err = encodeAuthErrorReply(call.Xid, stat)
+			} else {
+				reply, err = encodeReply(call.Xid, Success, nil)
+			}
+			if err != nil {
+				return
+			}
+			if _, err := WriteFullRecord(serverConn, reply); err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { clientConn.Close() })
+
+	return clientConn, func() []byte {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]byte(nil), creds...)
+	}
+}
+
+func TestClientRefreshCredential(t *testing.T) {
+	for _, stat := range []AuthStat{AuthRejectedcred, AuthRejectedVerf} {
+		// A call rejected once is made again with the refreshed
+		// credential.
+		auth := &refreshTestAuth{}
+		conn, creds := serveRejecting(t, 1, stat)
+		client := WrapClient(rpc.NewClientWithCodec(NewClientCodec(conn, nil, WithAuth(auth))))
+		if err := client.Call("ReplyStatTest.Call", nil, nil); err != nil {
+			t.Fatalf("auth stat %d: %v", stat, err)
+		}
+		if got := creds(); !bytes.Equal(got, []byte{0, 1}) {
+			t.Fatalf("auth stat %d: got credentials %v, want [0 1]", stat, got)
+		}
+		client.Close()
+
+		// A call rejected again fails, without being made a third time
+		auth = &refreshTestAuth{}
+		conn, creds = serveRejecting(t, 2, stat)
+		client = WrapClient(rpc.NewClientWithCodec(NewClientCodec(conn, nil, WithAuth(auth))))
+		var rejected ErrAuthRejected
+		if err := client.Call("ReplyStatTest.Call", nil, nil); !errors.As(err, &rejected) || rejected.Stat != stat {
+			t.Fatalf("auth stat %d: got error %v", stat, err)
+		}
+		if got := creds(); !bytes.Equal(got, []byte{0, 1}) {
+			t.Fatalf("auth stat %d: got credentials %v, want [0 1]", stat, got)
+		}
+		if auth.refreshes != 1 {
+			t.Fatalf("auth stat %d: refreshed %d times", stat, auth.refreshes)
+		}
+
+		// The client remains usable
+		if err := client.Call("ReplyStatTest.Call", nil, nil); err != nil {
+			t.Fatalf("auth stat %d: %v", stat, err)
+		}
+		client.Close()
+	}
+}
@@ -6,7 +6,10 @@ package sunrpc
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"io"
+	"log"
 	"net"
 	"net/rpc"
 	"sync"
@@ -18,31 +21,75 @@ type clientCodec struct {
 	conn         io.ReadWriteCloser // network connection
 	recordReader io.Reader          // reader for RPC record
 	notifyClose  chan<- io.ReadWriteCloser
+	auth         Auth // authenticates calls
 
 	// Sun RPC responses include Seq (XID) but not ServiceMethod (procedure
 	// number). Go package net/rpc expects both. So we save ServiceMethod
 	// when sending the request and look it up when filling rpc.Response
-	mutex   sync.Mutex        // protects pending
-	pending map[uint64]string // maps Seq (XID) to ServiceMethod
+	mutex   sync.Mutex             // protects pending
+	pending map[uint64]pendingCall // maps Seq (XID) to call
+
+	// Calls are written by ReadResponseHeader() when made once more,
+	// concurrently with WriteRequest().
+	writeMutex sync.Mutex
+
+	// Replies read by ReadResponseHeader() while refreshing the
+	// authentication, to be returned first.
+	deferred [][]byte
+
+	// Set if the codec reconnects when the connection is lost. See
+	// WithReconnect. conn is replaced by ReadResponseHeader() and
+	// protected by mutex then.
+	redial  func(ctx context.Context) (io.ReadWriteCloser, error)
+	broken  bool            // connection lost, not replaced yet
+	lost    []uint64        // calls failed for the lost connection
+	closed  bool            // Close() was called
+	closing context.Context // done once Close() is called
+	cancel  context.CancelFunc
 }
 
+// pendingCall is a call awaiting reply
+type pendingCall struct {
+	serviceMethod string
+	procedureID   ProcedureID
+	param         interface{}
+	auth          AuthCall // authentication of the call
+	retried       bool     // call was made once more
+	state         *callState
+}
+
+// ClientOption configures optional behaviour of a client codec.
+type ClientOption func(*clientCodec)
+
 // NewClientCodec returns a new rpc.ClientCodec using Sun RPC on conn.
 // If a non-nil channel is passed as second argument, the conn is sent on
 // that channel when Close() is called on conn.
-func NewClientCodec(conn io.ReadWriteCloser, notifyClose chan<- io.ReadWriteCloser) rpc.ClientCodec {
-	return &clientCodec{
+//
+// A call failed by the server fails alone, leaving the client usable. With
+// a plain rpc.Client, its error is then an rpc.ServerError holding just the
+// message, which errors.Is no longer matches against errors such as
+// ErrProgMismatch. Wrap the client using WrapClient to get the typed error.
+func NewClientCodec(conn io.ReadWriteCloser, notifyClose chan<- io.ReadWriteCloser, opts ...ClientOption) rpc.ClientCodec {
+	c := &clientCodec{
 		conn:        conn,
 		notifyClose: notifyClose,
-		pending:     make(map[uint64]string),
+		auth:        noneAuth{},
+		pending:     make(map[uint64]pendingCall),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// NewClient returns a new rpc.Client which internally uses Sun RPC codec
+// NewClient returns a new rpc.Client which internally uses Sun RPC codec.
+// See NewClientCodec about the errors of calls failed by the server.
 func NewClient(conn io.ReadWriteCloser) *rpc.Client {
 	return rpc.NewClientWithCodec(NewClientCodec(conn, nil))
 }
 
-// Dial connects to a Sun-RPC server at the specified network address
+// Dial connects to a Sun-RPC server at the specified network address. See
+// NewClientCodec about the errors of calls failed by the server.
 func Dial(network, address string) (*rpc.Client, error) {
 	conn, err := net.Dial(network, address)
 	if err != nil {
@@ -51,6 +98,68 @@ func Dial(network, address string) (*rpc.Client, error) {
 	return NewClient(conn), err
 }
 
+// Client wraps a rpc.Client using a codec of this package. net/rpc reduces
+// the error of a failed call to a rpc.ServerError carrying just the message.
+// Calls made with Client return the *RPCError describing a failure reported
+// by the server, ErrTimeout or ErrConnectionLost instead.
+type Client struct {
+	*rpc.Client
+}
+
+// WrapClient returns a Client making calls with client, which must use a
+// codec of this package.
+func WrapClient(client *rpc.Client) *Client {
+	return &Client{client}
+}
+
+// Call invokes the named function, waits for it to complete, and returns
+// its error status.
+func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+
+	state := &callState{args: args}
+	err := c.Client.Call(serviceMethod, state, reply)
+	if state.err != nil {
+		return state.err
+	}
+
+	return err
+}
+
+// Go invokes the function asynchronously, like rpc.Client.Go. The Error of
+// the call returned is set as by Call.
+func (c *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+
+	if done == nil {
+		done = make(chan *rpc.Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("sunrpc: done channel is unbuffered")
+	}
+
+	call := &rpc.Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+
+	state := &callState{args: args}
+	inner := c.Client.Go(serviceMethod, state, reply, make(chan *rpc.Call, 1))
+	go func() {
+		<-inner.Done
+		call.Error = inner.Error
+		if state.err != nil {
+			call.Error = state.err
+		}
+		// Like net/rpc, don't block if done has no room
+		select {
+		case call.Done <- call:
+		default:
+		}
+	}()
+
+	return call
+}
+
 func (c *clientCodec) WriteRequest(req *rpc.Request, param interface{}) error {
 
 	// rpc.Request.Seq is initialized (from 0) and incremented by net/rpc
@@ -62,13 +171,77 @@ func (c *clientCodec) WriteRequest(req *rpc.Request, param interface{}) error {
 		return ErrProcUnavail
 	}
 
+	var state *callState
+	if s, ok := param.(*callState); ok {
+		state = s
+		param = s.args
+	}
+
+	return c.writeCall(req.Seq, pendingCall{
+		serviceMethod: req.ServiceMethod,
+		procedureID:   procedureID,
+		param:         param,
+		state:         state,
+	})
+}
+
+// writeCall authenticates the call and writes it to the network.
+func (c *clientCodec) writeCall(seq uint64, call pendingCall) error {
+
+	var err error
+	if call.auth, err = c.auth.NewCall(); err != nil {
+		return err
+	}
+
+	// Encapsulate rpc.Request.Seq and rpc.Request.ServiceMethod
+	payload, err := encodeAuthCall(uint32(seq), call.procedureID, call.param, call.auth)
+	if err != nil {
+		return err
+	}
+
 	c.mutex.Lock()
-	c.pending[req.Seq] = req.ServiceMethod
+	c.pending[seq] = call
+	conn, broken := c.conn, c.broken
 	c.mutex.Unlock()
 
-	// Encapsulate rpc.Request.Seq and rpc.Request.ServiceMethod
+	if broken {
+		// Made once connected again
+		return nil
+	}
+
+	// Write payload to network
+	c.writeMutex.Lock()
+	_, err = WriteFullRecord(conn, payload)
+	c.writeMutex.Unlock()
+	if err != nil {
+		if c.redial != nil {
+			// The connection is found lost by ReadResponseHeader(),
+			// which makes the call once more or fails it. Closing it
+			// makes sure reading fails too.
+			conn.Close()
+			return nil
+		}
+		if err == io.EOF && c.notifyClose != nil {
+			c.notifyClose <- conn
+		}
+		return err
+	}
+
+	return nil
+}
+
+// encodeCall returns the RPC call message for the procedure specified
+// followed by the marshalled params of the remote procedure.
+func encodeCall(xid uint32, procedureID ProcedureID, param interface{}) ([]byte, error) {
+	return encodeAuthCall(xid, procedureID, param, nil)
+}
+
+// encodeAuthCall is like encodeCall but the call carries the credential and
+// verifier of auth and the params are protected by it. auth may be nil.
+func encodeAuthCall(xid uint32, procedureID ProcedureID, param interface{}, auth AuthCall) ([]byte, error) {
+
 	call := RPCMsg{
-		Xid:  uint32(req.Seq),
+		Xid:  xid,
 		Type: Call,
 		CBody: CallBody{
 			RPCVersion: RPCProtocolVersion,
@@ -78,32 +251,52 @@ func (c *clientCodec) WriteRequest(req *rpc.Request, param interface{}) error {
 		},
 	}
 
+	if auth != nil {
+		call.CBody.Cred = auth.Credential()
+	}
+
 	payload := new(bytes.Buffer)
 
 	if _, err := xdr.Marshal(payload, &call); err != nil {
-		return err
+		return nil, err
+	}
+
+	if auth != nil {
+		// The verifier is computed over the header of the call up to and
+		// including the credential, which is followed by the (empty)
+		// verifier marshalled above.
+		header := payload.Bytes()[:payload.Len()-emptyOpaqueAuthSize]
+		verf, err := auth.Verifier(header)
+		if err != nil {
+			return nil, err
+		}
+		payload.Truncate(len(header))
+		if _, err := xdr.Marshal(payload, &verf); err != nil {
+			return nil, err
+		}
 	}
 
+	// Marshall actual params/args of the remote procedure
+	args := new(bytes.Buffer)
 	if param != nil {
-		// Marshall actual params/args of the remote procedure
-		if _, err := xdr.Marshal(payload, &param); err != nil {
-			return err
+		if _, err := xdr.Marshal(args, &param); err != nil {
+			return nil, err
 		}
 	}
 
-	// Write payload to network
-	_, err := WriteFullRecord(c.conn, payload.Bytes())
-	if err != nil {
-		if err == io.EOF && c.notifyClose != nil {
-			c.notifyClose <- c.conn
+	body := args.Bytes()
+	if wrapper, ok := auth.(authWrapper); ok {
+		var err error
+		if body, err = wrapper.wrapArgs(body); err != nil {
+			return nil, err
 		}
-		return err
 	}
+	payload.Write(body)
 
-	return nil
+	return payload.Bytes(), nil
 }
 
-func (c *clientCodec) checkReplyForErr(reply *RPCMsg) error {
+func checkReplyForErr(reply *RPCMsg) error {
 
 	if reply.Type != Reply {
 		return ErrInvalidRPCMessageType
@@ -111,23 +304,7 @@ func (c *clientCodec) checkReplyForErr(reply *RPCMsg) error {
 
 	switch reply.RBody.Stat {
 	case MsgAccepted:
-		switch reply.RBody.Areply.Stat {
-		case Success:
-		case ProgMismatch:
-			return ErrProgMismatch{
-				reply.RBody.Areply.MismatchInfo.Low,
-				reply.RBody.Areply.MismatchInfo.High}
-		case ProgUnavail:
-			return ErrProgUnavail
-		case ProcUnavail:
-			return ErrProcUnavail
-		case GarbageArgs:
-			return ErrGarbageArgs
-		case SystemErr:
-			return ErrSystemErr
-		default:
-			return ErrInvalidMsgAccepted
-		}
+		return acceptStatErr(reply.RBody.Areply)
 	case MsgDenied:
 		switch reply.RBody.Rreply.Stat {
 		case RPCMismatch:
@@ -135,48 +312,237 @@ func (c *clientCodec) checkReplyForErr(reply *RPCMsg) error {
 				reply.RBody.Rreply.MismatchInfo.Low,
 				reply.RBody.Rreply.MismatchInfo.High}
 		case AuthError:
-			return ErrAuthError
+			return ErrAuthRejected{reply.RBody.Rreply.AuthStat}
 		default:
 			return ErrInvalidMsgDeniedType
 		}
 	default:
 		return ErrInvalidRPCRepyType
 	}
+}
+
+// replyError returns the error reported by reply, if any. Failures reported
+// by the server are returned as *RPCError describing the call to the
+// procedure specified, made to addr (which may be nil).
+func replyError(reply *RPCMsg, procedureID ProcedureID, addr net.Addr) error {
+
+	err := checkReplyForErr(reply)
+	if err == nil || reply.Type != Reply {
+		return err
+	}
+
+	rerr := &RPCError{
+		Xid:         reply.Xid,
+		ProcedureID: procedureID,
+		RemoteAddr:  addr,
+		ReplyStat:   reply.RBody.Stat,
+		Err:         err,
+	}
+
+	switch reply.RBody.Stat {
+	case MsgAccepted:
+		rerr.AcceptStat = reply.RBody.Areply.Stat
+		if rerr.AcceptStat == ProgMismatch {
+			rerr.Low = reply.RBody.Areply.MismatchInfo.Low
+			rerr.High = reply.RBody.Areply.MismatchInfo.High
+		}
+	case MsgDenied:
+		rerr.RejectStat = reply.RBody.Rreply.Stat
+		switch rerr.RejectStat {
+		case RPCMismatch:
+			rerr.Low = reply.RBody.Rreply.MismatchInfo.Low
+			rerr.High = reply.RBody.Rreply.MismatchInfo.High
+		case AuthError:
+			rerr.AuthStat = reply.RBody.Rreply.AuthStat
+		}
+	default:
+		return err
+	}
+
+	return rerr
+}
+
+// callState carries the args of a call made with Client to the codec, which
+// records in it the error the call failed with.
+type callState struct {
+	args interface{}
+	err  error
+}
+
+// failCall makes net/rpc complete the call of resp with err, keeping the
+// client usable. err is recorded in state, which is nil unless the call was
+// made with Client.
+func failCall(resp *rpc.Response, state *callState, err error) {
+	resp.Error = err.Error()
+	if state != nil {
+		state.err = err
+	}
+}
 
+// remoteAddr returns the address of the peer of conn if it's a net.Conn.
+func remoteAddr(conn interface{}) net.Addr {
+	if c, ok := conn.(net.Conn); ok {
+		return c.RemoteAddr()
+	}
 	return nil
 }
 
+// acceptStatErr returns the error represented by the status of a reply to
+// an accepted call.
+func acceptStatErr(areply AcceptedReply) error {
+
+	switch areply.Stat {
+	case Success:
+		return nil
+	case ProgMismatch:
+		return ErrProgMismatch{areply.MismatchInfo.Low, areply.MismatchInfo.High}
+	case ProgUnavail:
+		return ErrProgUnavail
+	case ProcUnavail:
+		return ErrProcUnavail
+	case GarbageArgs:
+		return ErrGarbageArgs
+	case SystemErr:
+		return ErrSystemErr
+	}
+
+	return ErrInvalidMsgAccepted
+}
+
 func (c *clientCodec) ReadResponseHeader(resp *rpc.Response) error {
 
-	// Read entire RPC message from network
-	record, err := ReadFullRecord(c.conn)
-	if err != nil {
-		if err == io.EOF && c.notifyClose != nil {
-			c.notifyClose <- c.conn
+	for {
+		if c.redial != nil {
+			// Calls failed for a lost connection are completed first,
+			// then the connection is replaced.
+			if seq, call, ok := c.nextLost(); ok {
+				resp.Seq = seq
+				resp.ServiceMethod = call.serviceMethod
+				failCall(resp, call.state, ErrConnectionLost)
+				return nil
+			}
+			if reconnected, err := c.reconnect(); err != nil {
+				return err
+			} else if reconnected {
+				continue
+			}
 		}
-		return err
+
+		// Read entire RPC message from network
+		record, err := c.readRecord()
+		if err != nil {
+			if err == io.EOF && c.notifyClose != nil {
+				c.notifyClose <- c.conn
+			}
+			if c.redial != nil && c.connLost() {
+				continue
+			}
+			return err
+		}
+
+		c.recordReader = bytes.NewReader(record)
+
+		// Unmarshal record as RPC reply
+		var reply RPCMsg
+		if _, err = xdr.Unmarshal(c.recordReader, &reply); err != nil {
+			return err
+		}
+
+		// Unpack rpc.Request.Seq and set rpc.Request.ServiceMethod
+		resp.Seq = uint64(reply.Xid)
+		c.mutex.Lock()
+		call := c.pending[resp.Seq]
+		delete(c.pending, resp.Seq)
+		c.mutex.Unlock()
+		resp.ServiceMethod = call.serviceMethod
+
+		if err := replyError(&reply, call.procedureID, remoteAddr(c.conn)); err != nil {
+			// Calls rejected for a stale credential are made once more
+			// with a fresh one, without net/rpc knowing.
+			if c.retryCall(&reply, &call) {
+				if err := c.writeCall(resp.Seq, call); err != nil {
+					return err
+				}
+				continue
+			}
+			if rerr, ok := err.(*RPCError); ok {
+				failCall(resp, call.state, rerr)
+				return nil
+			}
+			return err
+		}
+
+		if call.auth != nil {
+			if c.recordReader, err = unwrapReply(&reply, c.recordReader, call.auth); err != nil {
+				return err
+			}
+		}
+
+		return nil
 	}
+}
 
-	c.recordReader = bytes.NewReader(record)
+// retryCall returns true if the call rejected by reply is to be made once
+// more and marks it as such.
+func (c *clientCodec) retryCall(reply *RPCMsg, call *pendingCall) bool {
 
-	// Unmarshal record as RPC reply
-	var reply RPCMsg
-	if _, err = xdr.Unmarshal(c.recordReader, &reply); err != nil {
-		return err
+	if call.auth == nil || call.retried || reply.RBody.Stat != MsgDenied || reply.RBody.Rreply.Stat != AuthError {
+		return false
 	}
 
-	// Unpack rpc.Request.Seq and set rpc.Request.ServiceMethod
-	resp.Seq = uint64(reply.Xid)
-	c.mutex.Lock()
-	resp.ServiceMethod = c.pending[resp.Seq]
-	delete(c.pending, resp.Seq)
-	c.mutex.Unlock()
+	if !call.auth.Refresh(reply.RBody.Rreply.AuthStat) {
+		return false
+	}
+	if r, ok := c.auth.(authRefresher); ok {
+		if err := r.refresh(c.exchange, remoteAddr(c.conn)); err != nil {
+			return false
+		}
+	}
 
-	if err := c.checkReplyForErr(&reply); err != nil {
-		return err
+	call.retried = true
+	return true
+}
+
+// readRecord returns the next reply, read from the network unless one was
+// deferred.
+func (c *clientCodec) readRecord() ([]byte, error) {
+	if len(c.deferred) > 0 {
+		record := c.deferred[0]
+		c.deferred = c.deferred[1:]
+		return record, nil
 	}
+	return ReadFullRecord(c.conn)
+}
 
-	return nil
+// exchange makes the call with the xid specified outside of net/rpc and
+// returns the record of its reply. Replies to other calls read meanwhile are
+// deferred. It's called by ReadResponseHeader(), so replies are read while
+// waiting for calls made concurrently to be written.
+func (c *clientCodec) exchange(xid uint32, payload []byte) ([]byte, error) {
+
+	conn := c.conn
+	written := make(chan error, 1)
+	go func() {
+		c.writeMutex.Lock()
+		_, err := WriteFullRecord(conn, payload)
+		c.writeMutex.Unlock()
+		if err != nil {
+			// Makes sure reading fails too
+			conn.Close()
+		}
+		written <- err
+	}()
+
+	for {
+		record, err := ReadFullRecord(conn)
+		if err != nil {
+			return nil, err
+		}
+		if len(record) >= 4 && binary.BigEndian.Uint32(record) == xid {
+			return record, <-written
+		}
+		c.deferred = append(c.deferred, record)
+	}
 }
 
 func (c *clientCodec) ReadResponseBody(result interface{}) error {
@@ -194,5 +560,21 @@ func (c *clientCodec) ReadResponseBody(result interface{}) error {
 }
 
 func (c *clientCodec) Close() error {
-	return c.conn.Close()
+	c.mutex.Lock()
+	conn := c.conn
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.closed = true
+	c.mutex.Unlock()
+
+	if d, ok := c.auth.(authDestroyer); ok {
+		// Best effort; the reply isn't waited for
+		if payload, err := d.destroyCall(); err == nil {
+			c.writeMutex.Lock()
+			_, _ = WriteFullRecord(conn, payload)
+			c.writeMutex.Unlock()
+		}
+	}
+	return conn.Close()
 }
@@ -0,0 +1,493 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Command rpcinfo reports RPC information, like the rpcinfo command found on
+// most Unix systems, using the portmapper and client APIs of package sunrpc.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prashanthpai/sunrpc"
+)
+
+const (
+	// Time to wait for a program to reply to a NULL call
+	callTimeout = 10 * time.Second
+
+	// Max number of versions called one by one when probing a program.
+	// Beyond it, only the lowest and highest versions are called, as a
+	// program replying to every version claims all 2^32 of them.
+	maxPingVersions = 32
+)
+
+const usage = `usage: rpcinfo [-m | -s] [host]
+       rpcinfo -p [host]
+       rpcinfo -t host prognum [versnum]
+       rpcinfo -u host prognum [versnum]
+       rpcinfo -b prognum versnum
+       rpcinfo -d [-T netid] prognum versnum
+`
+
+var (
+	// errUsage is returned for a malformed command line.
+	errUsage = errors.New("usage")
+
+	// errFailed is returned when the failure has already been reported.
+	errFailed = errors.New("failed")
+)
+
+// command is a parsed command line. mode is the flag selecting what to do,
+// or empty string to list the registered programs.
+type command struct {
+	mode  string
+	netid string
+	rpcdb string
+	args  []string
+}
+
+// Flags selecting what to do, in order of precedence
+var modes = []struct {
+	name  string
+	usage string
+}{
+	{"p", "list programs registered with the portmapper"},
+	{"s", "list registered programs concisely"},
+	{"m", "print rpcbind statistics"},
+	{"t", "call procedure 0 of a program over TCP"},
+	{"u", "call procedure 0 of a program over UDP"},
+	{"b", "broadcast a call to procedure 0 of a program over UDP"},
+	{"d", "delete registration of a program"},
+}
+
+// newFlagSet returns the flags of the command, which set the fields of c
+// other than mode. The flags selecting the mode are set in selected.
+func newFlagSet(c *command, selected map[string]*bool) *flag.FlagSet {
+
+	fs := flag.NewFlagSet("rpcinfo", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	for _, m := range modes {
+		selected[m.name] = fs.Bool(m.name, false, m.usage)
+	}
+	fs.StringVar(&c.netid, "T", "", "netid of the registration to delete")
+	fs.StringVar(&c.rpcdb, "rpcdb", "/etc/rpc", "RPC program number database")
+
+	return fs
+}
+
+// parseCommand parses the command line arguments, without the command name.
+// errUsage is returned if the number of arguments doesn't match the mode.
+func parseCommand(args []string) (*command, error) {
+
+	c := new(command)
+	selected := make(map[string]*bool)
+	fs := newFlagSet(c, selected)
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	c.args = fs.Args()
+
+	for _, m := range modes {
+		if *selected[m.name] {
+			c.mode = m.name
+			break
+		}
+	}
+
+	var minArgs, maxArgs int
+	switch c.mode {
+	case "", "p", "s", "m":
+		minArgs, maxArgs = 0, 1
+	case "t", "u":
+		minArgs, maxArgs = 2, 3
+	case "b", "d":
+		minArgs, maxArgs = 2, 2
+	}
+	if len(c.args) < minArgs || len(c.args) > maxArgs {
+		return nil, errUsage
+	}
+
+	return c, nil
+}
+
+func main() {
+
+	err := run(os.Args[1:], os.Stdout)
+	switch err {
+	case nil:
+		return
+	case errUsage:
+		fmt.Fprint(os.Stderr, usage)
+		fs := newFlagSet(new(command), make(map[string]*bool))
+		fs.SetOutput(os.Stderr)
+		fs.PrintDefaults()
+	case errFailed:
+	default:
+		fmt.Fprintf(os.Stderr, "rpcinfo: %s\n", err)
+	}
+	os.Exit(1)
+}
+
+// rpcinfo carries out commands, writing their output to out.
+type rpcinfo struct {
+	out io.Writer
+	db  *rpcDB
+}
+
+// run carries out the command line specified by args.
+func run(args []string, out io.Writer) error {
+
+	c, err := parseCommand(args)
+	if err != nil {
+		return err
+	}
+
+	r := &rpcinfo{out: out, db: loadRPCDB(c.rpcdb)}
+	host := ""
+	if len(c.args) > 0 {
+		host = c.args[0]
+	}
+
+	switch c.mode {
+	case "p":
+		return r.pmapDump(host)
+	case "s":
+		return r.rpcbDump(host, true)
+	case "m":
+		return r.rpcbStat(host)
+	case "t":
+		return r.ping(c.args, sunrpc.IPProtoTCP)
+	case "u":
+		return r.ping(c.args, sunrpc.IPProtoUDP)
+	case "b":
+		return r.broadcast(c.args)
+	case "d":
+		return r.unset(c.args, c.netid)
+	}
+
+	return r.rpcbDump(host, false)
+}
+
+// programVersion parses the program and optional version number arguments.
+// It returns false if the version isn't specified.
+func (r *rpcinfo) programVersion(args []string) (uint32, uint32, bool, error) {
+
+	program, ok := r.db.program(args[0])
+	if !ok {
+		return 0, 0, false, fmt.Errorf("%s is unknown service", args[0])
+	}
+
+	if len(args) == 1 {
+		return program, 0, false, nil
+	}
+
+	version, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("%s is illegal version number", args[1])
+	}
+
+	return program, uint32(version), true, nil
+}
+
+func (r *rpcinfo) pmapDump(host string) error {
+
+	maps, err := sunrpc.PmapGetMaps(host)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(r.out, "   program vers proto   port  service\n")
+	for _, m := range maps {
+		proto := sunrpc.ProtocolToNetid(sunrpc.Protocol(m.Protocol))
+		if proto == "" {
+			proto = strconv.Itoa(int(m.Protocol))
+		}
+		fmt.Fprintf(r.out, "%10d%5d%6s%7d  %s\n", m.Program, m.Version, proto, m.Port, r.db.name(m.Program))
+	}
+
+	return nil
+}
+
+func (r *rpcinfo) rpcbDump(host string, concise bool) error {
+
+	bindings, err := sunrpc.RpcbDump(host)
+	if err != nil {
+		return err
+	}
+
+	if !concise {
+		fmt.Fprintf(r.out, "   program version netid     address                service    owner\n")
+		for _, b := range bindings {
+			fmt.Fprintf(r.out, "%10d%5d    %-10s%-23s%-11s%s\n", b.Program, b.Version, b.Netid, b.Addr,
+				r.db.name(b.Program), owner(b.Owner))
+		}
+		return nil
+	}
+
+	// Group versions and netids by program
+	type summary struct {
+		versions []string
+		netids   []string
+		owner    string
+	}
+	var programs []uint32
+	summaries := make(map[uint32]*summary)
+	for _, b := range bindings {
+		s, ok := summaries[b.Program]
+		if !ok {
+			s = &summary{owner: owner(b.Owner)}
+			summaries[b.Program] = s
+			programs = append(programs, b.Program)
+		}
+		s.versions = appendUnique(s.versions, strconv.Itoa(int(b.Version)))
+		s.netids = appendUnique(s.netids, b.Netid)
+	}
+	sort.Slice(programs, func(i, j int) bool { return programs[i] < programs[j] })
+
+	fmt.Fprintf(r.out, "   program version(s) netid(s)                         service     owner\n")
+	for _, program := range programs {
+		s := summaries[program]
+		fmt.Fprintf(r.out, "%10d  %-10s %-32s %-11s %s\n", program, strings.Join(s.versions, ","),
+			strings.Join(s.netids, ","), r.db.name(program), s.owner)
+	}
+
+	return nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func owner(uid string) string {
+	switch uid {
+	case "":
+		return "unknown"
+	case "0":
+		return "superuser"
+	}
+	return uid
+}
+
+var statProcedures = [][]string{
+	{"NULL", "SET", "UNSET", "GETPORT", "DUMP", "CALLIT"},
+	{"NULL", "SET", "UNSET", "GETADDR", "DUMP", "CALLIT", "TIME", "U2T", "T2U"},
+	{"NULL", "SET", "UNSET", "GETADDR", "DUMP", "CALLIT", "TIME", "U2T", "T2U",
+		"VERADDR", "INDRECT", "GETLIST", "GETSTAT"},
+}
+
+func (r *rpcinfo) rpcbStat(host string) error {
+
+	stats, err := sunrpc.RpcbGetStat(host)
+	if err != nil {
+		return err
+	}
+
+	titles := []string{"PORTMAP (version 2)", "RPCBIND (version 3)", "RPCBIND (version 4)"}
+	for i, stat := range stats {
+		if i >= len(titles) {
+			break
+		}
+		fmt.Fprintf(r.out, "\n%s statistics\n", titles[i])
+		for _, name := range statProcedures[i] {
+			fmt.Fprintf(r.out, "%-8s", name)
+		}
+		fmt.Fprintln(r.out)
+		for j := range statProcedures[i] {
+			fmt.Fprintf(r.out, "%-8d", stat.Info[j])
+		}
+		fmt.Fprintln(r.out)
+
+		if len(stat.AddrInfo) > 0 {
+			lookup := "GETADDR"
+			if i == 0 {
+				lookup = "GETPORT"
+			}
+			fmt.Fprintf(r.out, "\n%s call statistics\n", lookup)
+			fmt.Fprintf(r.out, "prog\t\tvers\tnetid\tsuccess\tfailure\n")
+			for _, a := range stat.AddrInfo {
+				fmt.Fprintf(r.out, "%-16s%d\t%s\t%d\t%d\n", r.programName(a.Program), a.Version, a.Netid,
+					a.Success, a.Failure)
+			}
+		}
+
+		if len(stat.RmtInfo) > 0 {
+			fmt.Fprintf(r.out, "\nCALLIT call statistics\n")
+			fmt.Fprintf(r.out, "prog\t\tvers\tproc\tnetid\tindirect success failure\n")
+			for _, rmt := range stat.RmtInfo {
+				fmt.Fprintf(r.out, "%-16s%d\t%d\t%s\t%d\t %d\t %d\n", r.programName(rmt.Program), rmt.Version,
+					rmt.Procedure, rmt.Netid, rmt.Indirect, rmt.Success, rmt.Failure)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *rpcinfo) programName(program uint32) string {
+	if name := r.db.name(program); name != "-" {
+		return name
+	}
+	return strconv.Itoa(int(program))
+}
+
+// nullProcedure returns the name of procedure 0 of the program, registering
+// it in the procedure registry if required.
+func nullProcedure(program, version uint32) string {
+	id := sunrpc.ProcedureID{ProgramNumber: program, ProgramVersion: version}
+	if name, ok := sunrpc.GetProcedureName(id); ok {
+		return name
+	}
+	name := fmt.Sprintf("Prog%dV%d.ProcNull", program, version)
+	_ = sunrpc.RegisterProcedure(sunrpc.Procedure{ID: id, Name: name}, false)
+	return name
+}
+
+// programAddr looks up the address of the program on host over the
+// protocol specified with the portmapper on host. Any version of the
+// program will do if version isn't specified.
+func programAddr(host string, protocol sunrpc.Protocol, program, version uint32, versionSpecified bool) (net.Addr, error) {
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	hostname = strings.TrimSuffix(strings.TrimPrefix(hostname, "["), "]")
+	if hostname == "" {
+		hostname = "localhost"
+	}
+
+	netid := sunrpc.ProtocolToNetid(protocol)
+	if ip := net.ParseIP(hostname); ip != nil && ip.To4() == nil {
+		netid += "6"
+	}
+
+	bindings, err := sunrpc.RpcbDump(host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range bindings {
+		if b.Program != program || b.Netid != netid || (versionSpecified && b.Version != version) {
+			continue
+		}
+		addr, err := sunrpc.ParseUniversalAddress(b.Netid, b.Addr)
+		if err != nil {
+			return nil, err
+		}
+		// The program is reached on the host of the portmapper
+		_, port, _ := net.SplitHostPort(addr.String())
+		network, _ := sunrpc.NetidNetwork(netid)
+		if protocol == sunrpc.IPProtoUDP {
+			return net.ResolveUDPAddr(network, net.JoinHostPort(hostname, port))
+		}
+		return net.ResolveTCPAddr(network, net.JoinHostPort(hostname, port))
+	}
+
+	return nil, sunrpc.ErrProgNotRegistered
+}
+
+func (r *rpcinfo) ping(args []string, protocol sunrpc.Protocol) error {
+
+	host := args[0]
+	program, version, ok, err := r.programVersion(args[1:])
+	if err != nil {
+		return err
+	}
+
+	addr, err := programAddr(host, protocol, program, version, ok)
+	if err != nil {
+		return fmt.Errorf("%s\nprogram %d is not available", err, program)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	versions := []uint32{version}
+	if !ok {
+		low, high, err := sunrpc.ProbeVersions(ctx, addr, program)
+		if err != nil {
+			return fmt.Errorf("%s\nprogram %d is not available", err, program)
+		}
+		versions = []uint32{low, high}
+		if high-low < maxPingVersions {
+			versions = nil
+			for v := uint64(low); v <= uint64(high); v++ {
+				versions = append(versions, uint32(v))
+			}
+		}
+	}
+
+	failed := false
+	for _, v := range versions {
+		if err := sunrpc.Ping(ctx, addr, program, v); err != nil {
+			fmt.Fprintf(r.out, "rpcinfo: RPC: %s\nprogram %d version %d is not available\n", err, program, v)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(r.out, "program %d version %d ready and waiting\n", program, v)
+	}
+
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+func (r *rpcinfo) broadcast(args []string) error {
+
+	program, version, _, err := r.programVersion(args)
+	if err != nil {
+		return err
+	}
+
+	err = sunrpc.PmapBroadcast(context.Background(), "", nullProcedure(program, version), nil,
+		func() interface{} { return nil },
+		func(addr net.Addr, reply interface{}) bool {
+			host := addr.String()
+			if a, ok := addr.(*net.UDPAddr); ok {
+				host = a.IP.String()
+			}
+			fmt.Fprintf(r.out, "%s\t%s\n", host, r.db.name(program))
+			return false
+		})
+	if err == sunrpc.ErrTimeout {
+		return fmt.Errorf("broadcast failed: %s", err)
+	}
+
+	return err
+}
+
+func (r *rpcinfo) unset(args []string, netid string) error {
+
+	program, version, _, err := r.programVersion(args)
+	if err != nil {
+		return err
+	}
+
+	ok, err := sunrpc.RpcbUnset(program, version, netid)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Could not delete registration for prog %d version %d", program, version)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prashanthpai/sunrpc"
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		args  []string
+		mode  string
+		netid string
+		rest  []string
+		err   error
+	}{
+		{nil, "", "", nil, nil},
+		{[]string{"host"}, "", "", []string{"host"}, nil},
+		{[]string{"-p"}, "p", "", nil, nil},
+		{[]string{"-s", "host"}, "s", "", []string{"host"}, nil},
+		{[]string{"-m", "host"}, "m", "", []string{"host"}, nil},
+		{[]string{"-t", "host", "nfs"}, "t", "", []string{"host", "nfs"}, nil},
+		{[]string{"-u", "host", "100003", "3"}, "u", "", []string{"host", "100003", "3"}, nil},
+		{[]string{"-t", "-u", "host", "nfs"}, "t", "", []string{"host", "nfs"}, nil},
+		{[]string{"-b", "nfs", "3"}, "b", "", []string{"nfs", "3"}, nil},
+		{[]string{"-d", "-T", "tcp", "nfs", "3"}, "d", "tcp", []string{"nfs", "3"}, nil},
+
+		{[]string{"host", "extra"}, "", "", nil, errUsage},
+		{[]string{"-p", "host", "extra"}, "", "", nil, errUsage},
+		{[]string{"-t", "host"}, "", "", nil, errUsage},
+		{[]string{"-u", "host", "nfs", "3", "extra"}, "", "", nil, errUsage},
+		{[]string{"-b", "nfs"}, "", "", nil, errUsage},
+		{[]string{"-d", "nfs"}, "", "", nil, errUsage},
+		{[]string{"-x"}, "", "", nil, errUsage},
+		{[]string{"-T"}, "", "", nil, errUsage},
+	}
+
+	for _, tc := range tests {
+		c, err := parseCommand(tc.args)
+		if err != tc.err {
+			t.Errorf("%q: got error %v, want %v", tc.args, err, tc.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if c.mode != tc.mode || c.netid != tc.netid || strings.Join(c.args, " ") != strings.Join(tc.rest, " ") {
+			t.Errorf("%q: got mode %q, netid %q, args %q, want %q, %q, %q", tc.args,
+				c.mode, c.netid, c.args, tc.mode, tc.netid, tc.rest)
+		}
+	}
+}
+
+// servePrograms serves programs over TCP on the local host, which reply
+// to NULL calls of the versions registered, and returns the address of
+// the listener.
+func servePrograms(t *testing.T) *net.TCPAddr {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	server := rpc.NewServer()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(sunrpc.NewServerCodec(conn, nil))
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr)
+}
+
+// serveAnyVersion serves a program over TCP on the local host, which
+// replies with success to every call whatever the version, and returns the
+// address of the listener.
+func serveAnyVersion(t *testing.T) *net.TCPAddr {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			record, err := sunrpc.ReadFullRecord(conn)
+			if err != nil {
+				return
+			}
+			var call sunrpc.RPCMsg
+			if _, err := xdr.Unmarshal(bytes.NewReader(record), &call); err != nil {
+				return
+			}
+
+			var buf bytes.Buffer
+			reply := sunrpc.RPCMsg{Xid: call.Xid, Type: sunrpc.Reply, RBody: sunrpc.ReplyBody{
+				Stat: sunrpc.MsgAccepted, Areply: sunrpc.AcceptedReply{Stat: sunrpc.Success}}}
+			if _, err := xdr.Marshal(&buf, &reply); err != nil {
+				return
+			}
+			if _, err := sunrpc.WriteFullRecord(conn, buf.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr)
+}
+
+// servePmap serves a portmapper over TCP on the local host with the
+// mappings specified and returns the address of its listener.
+func servePmap(t *testing.T, mappings ...sunrpc.RPCB) string {
+	t.Helper()
+
+	s, err := sunrpc.NewPmapServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mappings {
+		if ok, err := s.Set(m); !ok || err != nil {
+			t.Fatal(ok, err)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+
+	return l.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	id := sunrpc.ProcedureID{ProgramNumber: 66630, ProgramVersion: 1, ProcedureNumber: 1}
+	if err := sunrpc.RegisterProcedure(sunrpc.Procedure{ID: id, Name: "RpcinfoTest.Call"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	uaddr := func(addr net.Addr) string {
+		_, uaddr, err := sunrpc.FormatUniversalAddress(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uaddr
+	}
+	served := servePrograms(t)
+	anyVersion := serveAnyVersion(t)
+	host := servePmap(t,
+		sunrpc.RPCB{Program: 66630, Version: 1, Netid: sunrpc.NetidTCP, Addr: uaddr(served), Owner: "1234"},
+		sunrpc.RPCB{Program: 66631, Version: 1, Netid: sunrpc.NetidTCP, Addr: uaddr(anyVersion), Owner: "0"},
+	)
+	rpcdb := filepath.Join(t.TempDir(), "rpc")
+
+	tests := []struct {
+		args []string
+		out  string
+		err  string
+	}{
+		{[]string{"-p", host}, "   program vers proto   port  service\n" +
+			fmt.Sprintf("%10d%5d%6s%7d  %s\n", 66630, 1, "tcp", served.Port, "-") +
+			fmt.Sprintf("%10d%5d%6s%7d  %s\n", 66631, 1, "tcp", anyVersion.Port, "-"), ""},
+		{[]string{host}, "   program version netid     address                service    owner\n" +
+			fmt.Sprintf("%10d%5d    %-10s%-23s%-11s%s\n", 66630, 1, "tcp", uaddr(served), "-", "1234") +
+			fmt.Sprintf("%10d%5d    %-10s%-23s%-11s%s\n", 66631, 1, "tcp", uaddr(anyVersion), "-", "superuser"), ""},
+		{[]string{"-s", host}, "   program version(s) netid(s)                         service     owner\n" +
+			fmt.Sprintf("%10d  %-10s %-32s %-11s %s\n", 66630, "1", "tcp", "-", "1234") +
+			fmt.Sprintf("%10d  %-10s %-32s %-11s %s\n", 66631, "1", "tcp", "-", "superuser"), ""},
+		{[]string{"-t", host, "66630"}, "program 66630 version 1 ready and waiting\n", ""},
+		{[]string{"-t", host, "66630", "1"}, "program 66630 version 1 ready and waiting\n", ""},
+
+		// A program replying to every version is called with the lowest
+		// and highest only
+		{[]string{"-t", host, "66631"}, "program 66631 version 0 ready and waiting\n" +
+			"program 66631 version 4294967295 ready and waiting\n", ""},
+
+		{[]string{"-t", host, "66632"}, "", "program 66632 is not available"},
+		{[]string{"-t", host, "nosuchprogram"}, "", "nosuchprogram is unknown service"},
+		{[]string{"-t", host, "nfs", "three"}, "", "three is illegal version number"},
+		{[]string{"-b", "nfs"}, "", errUsage.Error()},
+	}
+
+	for _, tc := range tests {
+		var out bytes.Buffer
+		err := run(append([]string{"-rpcdb", rpcdb}, tc.args...), &out)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%q: got error %v, want %q", tc.args, err, tc.err)
+		}
+		if out.String() != tc.out {
+			t.Errorf("%q: got output\n%s\nwant\n%s", tc.args, out.String(), tc.out)
+		}
+	}
+}
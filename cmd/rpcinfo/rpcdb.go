@@ -0,0 +1,104 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Well known programs used when the RPC program number database can't be
+// read.
+var builtinPrograms = map[uint32]string{
+	100000: "portmapper",
+	100001: "rstatd",
+	100002: "rusersd",
+	100003: "nfs",
+	100004: "ypserv",
+	100005: "mountd",
+	100007: "ypbind",
+	100008: "walld",
+	100009: "yppasswdd",
+	100011: "rquotad",
+	100021: "nlockmgr",
+	100024: "status",
+	100227: "nfs_acl",
+}
+
+// rpcDB maps RPC program numbers to names and back, as read from a file in
+// the format of /etc/rpc:
+//
+//	name	number	aliases...	# comment
+type rpcDB struct {
+	names    map[uint32]string
+	programs map[string]uint32
+}
+
+// loadRPCDB reads the RPC program number database at path. The built-in
+// list of well known programs is used if the file can't be read.
+func loadRPCDB(path string) *rpcDB {
+
+	db := &rpcDB{
+		names:    make(map[uint32]string),
+		programs: make(map[string]uint32),
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		for number, name := range builtinPrograms {
+			db.add(number, name)
+		}
+		return db
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		number, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		db.add(uint32(number), fields[0])
+		for _, alias := range fields[2:] {
+			db.programs[alias] = uint32(number)
+		}
+	}
+
+	return db
+}
+
+func (db *rpcDB) add(number uint32, name string) {
+	if _, ok := db.names[number]; !ok {
+		db.names[number] = name
+	}
+	db.programs[name] = number
+}
+
+// name returns the name of the program or "-" if it's unknown.
+func (db *rpcDB) name(number uint32) string {
+	if name, ok := db.names[number]; ok {
+		return name
+	}
+	return "-"
+}
+
+// program parses s as a program number or name.
+func (db *rpcDB) program(s string) (uint32, bool) {
+	if number, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(number), true
+	}
+	number, ok := db.programs[s]
+	return number, ok
+}
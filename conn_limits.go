@@ -0,0 +1,234 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// WithIdleTimeout makes the server close the connection once no call has
+// been in progress for the duration specified, so that idle or dead
+// clients don't hold on to it forever. Like any close of the connection,
+// it's reported on the notifyClose channel passed to NewServerCodec.
+func WithIdleTimeout(timeout time.Duration) ServerOption {
+	return func(c *serverCodec) {
+		c.idleTimeout = timeout
+	}
+}
+
+// WithMaxLifetime makes the server close the connection once it has been
+// open for the duration specified. No more calls are read then, and calls in
+// progress are replied to before the connection is closed; clients are
+// expected to connect again.
+// Like any close of the connection, it's reported on the notifyClose
+// channel passed to NewServerCodec.
+func WithMaxLifetime(lifetime time.Duration) ServerOption {
+	return func(c *serverCodec) {
+		c.maxLifetime = lifetime
+	}
+}
+
+// WithKeepAlive enables TCP keep-alive probes on connections over TCP,
+// sent with the period specified, so that the connections of clients that
+// vanished are found broken. A negative period disables keep-alive probes.
+// Zero keeps the setting of the connection.
+func WithKeepAlive(period time.Duration) ServerOption {
+	return func(c *serverCodec) {
+		c.keepAlive = period
+	}
+}
+
+// WithRecordTimeout makes the server close the connection if a call isn't
+// received in full within the duration specified from its first byte, so
+// that clients trickling bytes can't hold on to the connection. Reading the
+// call fails with ErrRecordTimeout then. The time waited for the first byte
+// is bounded by WithIdleTimeout instead.
+func WithRecordTimeout(timeout time.Duration) ServerOption {
+	return func(c *serverCodec) {
+		c.recordTimeout = timeout
+	}
+}
+
+// WithMinReadRate makes the server close the connection if a call is
+// received slower than the rate specified, in bytes per second, averaged
+// from its first byte with a grace of one second. Reading the call fails
+// with ErrRecordTimeout then.
+func WithMinReadRate(bytesPerSecond int) ServerOption {
+	return func(c *serverCodec) {
+		c.minReadRate = int64(bytesPerSecond)
+	}
+}
+
+// recordSource returns the reader to read the next record from, which
+// enforces the record timeout and minimum read rate, if any.
+func (c *serverCodec) recordSource() io.Reader {
+
+	conn, ok := c.conn.(net.Conn)
+	if !ok || (c.recordTimeout <= 0 && c.minReadRate <= 0) {
+		return c.conn
+	}
+
+	return &timedReader{
+		codec:   c,
+		conn:    conn,
+		timeout: c.recordTimeout,
+		minRate: c.minReadRate,
+	}
+}
+
+// timedReader reads a record from conn, setting read deadlines from the
+// first byte read on.
+type timedReader struct {
+	codec   *serverCodec
+	conn    net.Conn
+	timeout time.Duration
+	minRate int64     // bytes per second
+	start   time.Time // of the first byte; zero before
+	n       int64     // bytes read
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+
+	// No deadline applies while waiting for the first byte. The deadline
+	// set to stop reading when the codec is draining is kept.
+	r.codec.mutex.Lock()
+	var err error
+	if !r.codec.draining {
+		err = r.conn.SetReadDeadline(r.deadline())
+	}
+	r.codec.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := r.conn.Read(p)
+	if n > 0 && r.start.IsZero() {
+		r.start = time.Now()
+	}
+	r.n += int64(n)
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && !r.start.IsZero() {
+		err = ErrRecordTimeout
+	}
+
+	return n, err
+}
+
+// deadline returns the time by which more bytes of the record must be read.
+func (r *timedReader) deadline() time.Time {
+
+	if r.start.IsZero() {
+		return time.Time{}
+	}
+
+	var deadline time.Time
+	if r.timeout > 0 {
+		deadline = r.start.Add(r.timeout)
+	}
+	if r.minRate > 0 {
+		// Each byte read buys time at the minimum rate
+		allowed := time.Duration(float64(time.Second) * (float64(r.n)/float64(r.minRate) + 1))
+		if rateDeadline := r.start.Add(allowed); deadline.IsZero() || rateDeadline.Before(deadline) {
+			deadline = rateDeadline
+		}
+	}
+
+	return deadline
+}
+
+// startLimits applies the limits on the lifetime of the connection.
+func (c *serverCodec) startLimits() {
+
+	if tcpConn, ok := c.conn.(*net.TCPConn); ok && c.keepAlive != 0 {
+		err := tcpConn.SetKeepAlive(c.keepAlive > 0)
+		if err == nil && c.keepAlive > 0 {
+			err = tcpConn.SetKeepAlivePeriod(c.keepAlive)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.idleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(c.idleTimeout, c.idle)
+	}
+	if c.maxLifetime > 0 {
+		c.lifetimeTimer = time.AfterFunc(c.maxLifetime, c.expire)
+	}
+}
+
+// stopLimits stops the timers of the connection. c.mutex must be held.
+func (c *serverCodec) stopLimits() {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	if c.lifetimeTimer != nil {
+		c.lifetimeTimer.Stop()
+	}
+}
+
+// callStarted records that a call was handed to net/rpc. c.mutex must be
+// held.
+func (c *serverCodec) callStarted() {
+	c.inProgress++
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+}
+
+// callDone records that a call was replied to and returns true if the
+// connection is to be closed now. c.mutex must be held.
+func (c *serverCodec) callDone() bool {
+	if c.inProgress > 0 {
+		c.inProgress--
+	}
+	if c.inProgress > 0 {
+		return false
+	}
+	if c.expired {
+		return true
+	}
+	c.touch()
+	return false
+}
+
+// touch restarts the idle timeout when the client is active but no call is
+// in progress. c.mutex must be held.
+func (c *serverCodec) touch() {
+	if c.idleTimer != nil && c.inProgress == 0 && !c.closed {
+		c.idleTimer.Reset(c.idleTimeout)
+	}
+}
+
+// idle closes the connection if no call is in progress.
+func (c *serverCodec) idle() {
+	c.mutex.Lock()
+	idle := c.inProgress == 0
+	c.mutex.Unlock()
+
+	if idle {
+		c.Close()
+	}
+}
+
+// expire stops reading calls and closes the connection once no call is in
+// progress.
+func (c *serverCodec) expire() {
+	c.mutex.Lock()
+	c.expired = true
+	idle := c.inProgress == 0
+	c.mutex.Unlock()
+
+	c.drain()
+	if idle {
+		c.Close()
+	}
+}
@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// keepAlive returns whether keep-alive probes are enabled on conn and the
+// idle time before the first, in seconds.
+func keepAlive(t *testing.T, conn *net.TCPConn) (bool, int) {
+	t.Helper()
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var enabled, idle int
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if enabled, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); serr != nil {
+			return
+		}
+		idle, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return enabled != 0, idle
+}
+
+func TestKeepAlive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accept := func(opts ...ServerOption) *net.TCPConn {
+		t.Helper()
+
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		codec := NewServerCodec(conn, nil, opts...)
+		t.Cleanup(func() { codec.Close() })
+
+		return conn.(*net.TCPConn)
+	}
+
+	if enabled, idle := keepAlive(t, accept(WithKeepAlive(7*time.Second))); !enabled || idle != 7 {
+		t.Fatalf("got keep-alive %v after %ds, want after 7s", enabled, idle)
+	}
+	if enabled, _ := keepAlive(t, accept(WithKeepAlive(-1))); enabled {
+		t.Fatal("keep-alive not disabled")
+	}
+}
@@ -0,0 +1,274 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+var limitsTestProc = ProcedureID{ProgramNumber: 66627, ProgramVersion: 1, ProcedureNumber: 1}
+
+type LimitsTest struct{}
+
+// Sleep replies once the number of milliseconds specified elapsed.
+func (LimitsTest) Sleep(ms int32, reply *int32) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+// serveLimits serves LimitsTest on the connections accepted over TCP with
+// the options specified and returns the address of the listener and the
+// channel the connections are reported on when closed.
+func serveLimits(t *testing.T, opts ...ServerOption) (string, <-chan io.ReadWriteCloser) {
+	t.Helper()
+
+	if err := RegisterProcedure(Procedure{limitsTestProc, "LimitsTest.Sleep"}, true); err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.Register(LimitsTest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	notifyClose := make(chan io.ReadWriteCloser, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(NewServerCodec(conn, notifyClose, opts...))
+		}
+	}()
+
+	return listener.Addr().String(), notifyClose
+}
+
+// dialLimits returns a client connected to address.
+func dialLimits(t *testing.T, address string) *rpc.Client {
+	t.Helper()
+
+	client, err := Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// waitClosed waits for a connection to be reported closed on notifyClose,
+// which must happen between min and max from start.
+func waitClosed(t *testing.T, notifyClose <-chan io.ReadWriteCloser, start time.Time, min, max time.Duration) {
+	t.Helper()
+
+	select {
+	case <-notifyClose:
+	case <-time.After(time.Until(start.Add(max))):
+		t.Fatalf("connection not closed within %v", max)
+	}
+	if elapsed := time.Since(start); elapsed < min {
+		t.Fatalf("connection closed after %v, before %v", elapsed, min)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	address, notifyClose := serveLimits(t, WithIdleTimeout(timeout))
+	client := dialLimits(t, address)
+
+	// A call in progress for longer than the timeout isn't cut off
+	var reply int32
+	if err := client.Call("LimitsTest.Sleep", int32(2*timeout/time.Millisecond), &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// The timeout runs from the reply on
+	start := time.Now()
+	waitClosed(t, notifyClose, start, timeout-50*time.Millisecond, timeout+time.Second)
+	if err := client.Call("LimitsTest.Sleep", int32(0), &reply); err == nil {
+		t.Fatal("call made on the idle connection")
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	const lifetime = 200 * time.Millisecond
+	address, notifyClose := serveLimits(t, WithMaxLifetime(lifetime))
+	start := time.Now()
+	client := dialLimits(t, address)
+
+	// The call in progress when the connection expires is replied to, but
+	// no call is read afterwards.
+	slow := client.Go("LimitsTest.Sleep", int32(2*lifetime/time.Millisecond), new(int32), nil)
+	time.Sleep(lifetime + 50*time.Millisecond)
+	late := client.Go("LimitsTest.Sleep", int32(0), new(int32), nil)
+
+	if call := <-slow.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	if call := <-late.Done; call.Error == nil {
+		t.Fatal("call read after the connection expired")
+	}
+	waitClosed(t, notifyClose, start, 2*lifetime, 2*lifetime+time.Second)
+
+	// An idle connection is closed as soon as it expires
+	start = time.Now()
+	client = dialLimits(t, address)
+	var reply int32
+	if err := client.Call("LimitsTest.Sleep", int32(0), &reply); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, notifyClose, start, lifetime, lifetime+time.Second)
+}
+
+// errServerCodec records the error reading a call fails with.
+type errServerCodec struct {
+	rpc.ServerCodec
+	err chan error
+}
+
+func (c *errServerCodec) ReadRequestHeader(req *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(req)
+	if err != nil {
+		c.err <- err
+	}
+	return err
+}
+
+// acceptLimits serves LimitsTest with the options specified on a single
+// connection over TCP. It returns the client end and the channel the error
+// reading a call fails with is sent on.
+func acceptLimits(t *testing.T, opts ...ServerOption) (net.Conn, <-chan error) {
+	t.Helper()
+
+	if err := RegisterProcedure(Procedure{limitsTestProc, "LimitsTest.Sleep"}, true); err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.Register(LimitsTest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := &errServerCodec{NewServerCodec(conn, nil, opts...), make(chan error, 1)}
+	go server.ServeCodec(codec)
+
+	return client, codec.err
+}
+
+// trickle writes a call to conn a byte every interval, starting with the
+// fragment header, until writing fails.
+func trickle(t *testing.T, conn net.Conn, interval time.Duration) {
+	t.Helper()
+
+	payload, err := encodeCall(1, limitsTestProc, int32(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(record, createFragmentHeader(uint32(len(payload)), true))
+	record = append(record, payload...)
+
+	if _, err := conn.Write(record[:4]); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for _, b := range record[4:] {
+			time.Sleep(interval)
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestRecordTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []ServerOption
+		interval time.Duration // between the bytes written
+		min, max time.Duration // time to fail the call within
+	}{
+		// The call would take over 2s to be sent in full
+		{"record timeout", []ServerOption{WithRecordTimeout(300 * time.Millisecond)},
+			50 * time.Millisecond, 300 * time.Millisecond, time.Second},
+		// 20 bytes per second against 100, with a grace of one
+		// second, fail after 1.3s.
+		{"min read rate", []ServerOption{WithMinReadRate(100)},
+			50 * time.Millisecond, time.Second, 2 * time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, errs := acceptLimits(t, tc.opts...)
+			start := time.Now()
+			trickle(t, conn, tc.interval)
+
+			select {
+			case err := <-errs:
+				if err != ErrRecordTimeout {
+					t.Fatalf("got error %v, want %v", err, ErrRecordTimeout)
+				}
+			case <-time.After(tc.max):
+				t.Fatalf("call not failed within %v", tc.max)
+			}
+			if elapsed := time.Since(start); elapsed < tc.min {
+				t.Fatalf("call failed after %v, before %v", elapsed, tc.min)
+			}
+
+			// The connection is closed, which is found as a reset once
+			// more bytes were written.
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err := conn.Read(make([]byte, 1))
+			if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+				t.Fatalf("got error %v reading the closed connection", err)
+			}
+		})
+	}
+}
+
+func TestRecordTimeoutIdle(t *testing.T) {
+	// The time waited for the first byte of a call isn't limited
+	conn, errs := acceptLimits(t, WithRecordTimeout(100*time.Millisecond), WithMinReadRate(100))
+	time.Sleep(300 * time.Millisecond)
+
+	client := NewClient(conn)
+	var reply int32
+	if err := client.Call("LimitsTest.Sleep", int32(0), &reply); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("reading a call failed: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/rpc"
+	"strconv"
+	"time"
+)
+
+// DialOptions configures how DialProgram locates and connects to a program.
+type DialOptions struct {
+	// Protocols are the transports tried in order. If empty, TCP is tried
+	// first and then UDP.
+	Protocols []Protocol
+
+	// Pmap is used to query the portmapper (or rpcbind). Its Host field is
+	// ignored and replaced by the host passed to DialProgram.
+	Pmap PmapClient
+
+	// Cache, if set, is consulted instead of querying the portmapper on
+	// every dial.
+	Cache *PmapCache
+
+	// Timeout and Retransmit are used by clients over UDP. See
+	// NewUDPClientCodec for details.
+	Timeout    time.Duration
+	Retransmit time.Duration
+
+	// NotifyClose is passed on to the codec of clients over TCP. See
+	// NewClientCodec for details.
+	NotifyClose chan<- io.ReadWriteCloser
+
+	// TLSConfig, if set, makes the client upgrade connections over TCP to
+	// TLS using StartTLS. UDP isn't tried then.
+	TLSConfig *tls.Config
+
+	// Reconnect makes clients over TCP connect again when the connection
+	// is lost, looking up the port of the program once more. See
+	// WithReconnect.
+	Reconnect bool
+}
+
+// DialProgram looks up the program specified with the portmapper (or
+// rpcbind) on host and returns a client connected to it, just like
+// clnt_create() does. Calls made with the client are to procedures of the
+// program registered in the procedure registry. The transports in
+// opts.Protocols are tried in order till one succeeds; opts may be nil.
+// If host is empty string, localhost is used.
+//
+// The deadline of ctx, if any, bounds both the portmapper lookup and the
+// connection attempt but not the lifetime of the client returned.
+func DialProgram(ctx context.Context, host string, programNumber, programVersion uint32, opts *DialOptions) (*rpc.Client, error) {
+
+	if opts == nil {
+		opts = new(DialOptions)
+	}
+
+	protocols := opts.Protocols
+	if len(protocols) == 0 {
+		protocols = []Protocol{IPProtoTCP, IPProtoUDP}
+	}
+	if opts.TLSConfig != nil {
+		protocols = []Protocol{IPProtoTCP}
+	}
+
+	var err error
+	for _, protocol := range protocols {
+		var conn net.Conn
+		conn, err = dialProgram(ctx, host, programNumber, programVersion, protocol, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if protocol == IPProtoUDP {
+			codec := NewUDPClientCodec(conn, opts.Timeout, opts.Retransmit).(*udpClientCodec)
+			if opts.Cache != nil {
+				// Dialing over UDP succeeds even if the program is
+				// gone from the cached port, which shows on the
+				// first call instead.
+				cache := opts.Cache
+				codec.unreachable = func() {
+					cache.Invalidate(host, programNumber, programVersion, IPProtoUDP)
+				}
+			}
+			return rpc.NewClientWithCodec(codec), nil
+		}
+		if opts.TLSConfig != nil {
+			// Never fall back to a connection without TLS
+			if conn, err = dialTLS(ctx, conn, programNumber, programVersion, opts.TLSConfig); err != nil {
+				return nil, err
+			}
+		}
+		var clientOpts []ClientOption
+		if opts.Reconnect {
+			redialOpts := *opts
+			clientOpts = append(clientOpts, WithReconnect(func(ctx context.Context) (io.ReadWriteCloser, error) {
+				return redialProgram(ctx, host, programNumber, programVersion, &redialOpts)
+			}))
+		}
+		return rpc.NewClientWithCodec(NewClientCodec(conn, opts.NotifyClose, clientOpts...)), nil
+	}
+
+	return nil, err
+}
+
+// dialProgram connects to the program over a single transport.
+func dialProgram(ctx context.Context, host string, programNumber, programVersion uint32, protocol Protocol, opts *DialOptions) (net.Conn, error) {
+
+	if opts.Cache != nil {
+		return opts.Cache.DialContext(ctx, host, programNumber, programVersion, protocol)
+	}
+
+	network, err := protocolNetwork(protocol)
+	if err != nil {
+		return nil, err
+	}
+	netid, err := programNetid(host, protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := lookupClient(ctx, opts.Pmap, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := client.RpcbGetNetAddr(programNumber, programVersion, netid)
+	if err != nil {
+		return nil, err
+	}
+	if addr == nil {
+		return nil, ErrProgNotRegistered
+	}
+
+	// The address registered is often the wildcard address, so the program
+	// is reached on the host of the portmapper.
+	var dialer net.Dialer
+	address := net.JoinHostPort(programHost(host), strconv.Itoa(addrPort(addr)))
+	return dialer.DialContext(ctx, network, address)
+}
+
+// redialProgram connects to the program over TCP once more for a client
+// that lost its connection, within the deadline of ctx.
+func redialProgram(ctx context.Context, host string, programNumber, programVersion uint32, opts *DialOptions) (net.Conn, error) {
+
+	conn, err := dialProgram(ctx, host, programNumber, programVersion, IPProtoTCP, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TLSConfig != nil {
+		return dialTLS(ctx, conn, programNumber, programVersion, opts.TLSConfig)
+	}
+
+	return conn, nil
+}
+
+// dialTLS upgrades conn to TLS within the deadline of ctx, if any.
+func dialTLS(ctx context.Context, conn net.Conn, programNumber, programVersion uint32, config *tls.Config) (net.Conn, error) {
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	tlsConn, err := StartTLS(conn, programNumber, programVersion, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
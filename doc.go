@@ -4,5 +4,11 @@
 
 /*
 Package sunrpc implements ONC RPC (Sun RPC) as described by RFC 5531.
+
+Calls are made and served over TCP, UDP and Unix domain sockets. Unix domain
+sockets are stream transports like TCP, known by the "local" netid to
+rpcbind: connect with Dial("unix", path) and serve connections accepted on a
+Unix listener with NewServerCodec. On Linux, procedures are told the
+identity of local callers, as verified by the kernel, in CallInfo.Peer.
 */
 package sunrpc
@@ -7,12 +7,37 @@ package sunrpc
 import (
 	"errors"
 	"fmt"
+	"net"
 )
 
 // Internal errors
 var (
 	ErrInvalidFragmentSize    = errors.New("The RPC fragment size is invalid")
 	ErrRPCMessageSizeExceeded = errors.New("The RPC message size is too big")
+	ErrTooManyFragments       = errors.New("The RPC record has too many fragments")
+	ErrTimeout                = errors.New("Timed out waiting for RPC reply")
+	ErrNoConnection           = errors.New("No connection to the server is available")
+	ErrConnectionLost         = errors.New("The connection was lost before the RPC reply was received")
+	ErrRecordTimeout          = errors.New("Timed out reading the RPC record")
+	ErrServerClosed           = errors.New("The server is closed")
+)
+
+// Portmapper and rpcbind errors
+var (
+	ErrNetidUnsupported        = errors.New("The netid is not supported by portmapper")
+	ErrProtocolUnsupported     = errors.New("The protocol must be IPProtoTCP or IPProtoUDP")
+	ErrInvalidUniversalAddress = errors.New("The universal address is invalid")
+	ErrPmapSetFailed           = errors.New("The portmapper refused to register the program")
+	ErrProgNotRegistered       = errors.New("The program is not registered with the portmapper")
+	ErrPeerCredUnsupported     = errors.New("Peer credentials of Unix domain sockets aren't supported on this platform")
+)
+
+// Authentication errors
+var (
+	ErrInvalidReplyVerifier = errors.New("The verifier of the RPC reply is invalid")
+	ErrGSSContextFailed     = errors.New("The RPCSEC_GSS context could not be established or used")
+	ErrTLSUnsupported       = errors.New("The server doesn't support RPC-with-TLS")
+	ErrInvalidAuthSysParms  = errors.New("The AUTH_SYS credential is too long")
 )
 
 // RPC errors
@@ -39,6 +64,37 @@ func (e ErrProgMismatch) Error() string {
 	return fmt.Sprintf("Program version not supported. Lowest and highest supported versions are %d and %d respectively", e.Low, e.High)
 }
 
+// ErrAuthRejected contains the reason the remote server rejected the identity
+// of the caller. It matches ErrAuthError when using errors.Is.
+type ErrAuthRejected struct {
+	Stat AuthStat
+}
+
+var authStatText = map[AuthStat]string{
+	AuthBadcred:          "bad credential",
+	AuthRejectedcred:     "client must begin new session",
+	AuthBadverf:          "bad verifier",
+	AuthRejectedVerf:     "verifier expired or replayed",
+	AuthTooweak:          "rejected for security reasons",
+	AuthInvalidresp:      "bogus response verifier",
+	AuthFailed:           "reason unknown",
+	RPCSecGSSCredProblem: "no credentials for user",
+	RPCSecGSSCtxProblem:  "problem with context",
+}
+
+func (e ErrAuthRejected) Error() string {
+	text, ok := authStatText[e.Stat]
+	if !ok {
+		text = fmt.Sprintf("auth_stat %d", e.Stat)
+	}
+	return fmt.Sprintf("%s: %s", ErrAuthError, text)
+}
+
+// Is returns true if target is ErrAuthError.
+func (e ErrAuthRejected) Is(target error) bool {
+	return target == ErrAuthError
+}
+
 // Given that the remote server accepted the RPC call, following errors
 // represent error status of an attempt to call remote procedure
 var (
@@ -48,6 +104,41 @@ var (
 	ErrSystemErr   = errors.New("System error on remote server")
 )
 
+// RPCError is returned for a call which the remote server failed, as
+// reported by the status of its reply. Err is one of the errors above that
+// describes the failure, so errors.Is and errors.As can be used to find it.
+type RPCError struct {
+	Xid         uint32
+	ProcedureID ProcedureID
+	RemoteAddr  net.Addr // nil if unknown
+
+	ReplyStat  ReplyStat
+	AcceptStat AcceptStat // if ReplyStat is MsgAccepted
+	RejectStat RejectStat // if ReplyStat is MsgDenied
+	AuthStat   AuthStat   // if RejectStat is AuthError
+
+	// Lowest and highest versions supported by the server if AcceptStat is
+	// ProgMismatch or RejectStat is RPCMismatch
+	Low  uint32
+	High uint32
+
+	Err error
+}
+
+func (e *RPCError) Error() string {
+	msg := fmt.Sprintf("%s (xid %#x, program %d version %d procedure %d", e.Err, e.Xid,
+		e.ProcedureID.ProgramNumber, e.ProcedureID.ProgramVersion, e.ProcedureID.ProcedureNumber)
+	if e.RemoteAddr != nil {
+		msg += ", server " + e.RemoteAddr.String()
+	}
+	return msg + ")"
+}
+
+// Unwrap returns Err.
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
 // These errors represent invalid replies from server and auth rejection.
 var (
 	ErrInvalidRPCMessageType = errors.New("Invalid RPC message type received")
@@ -1,12 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"net/rpc"
-	"strconv"
+	"time"
 
 	"github.com/prashanthpai/sunrpc"
 )
@@ -17,26 +17,14 @@ func main() {
 	programVersion := uint32(1)
 
 	_ = sunrpc.RegisterProcedure(sunrpc.Procedure{
-		ID:   sunrpc.ProcedureID{programNumber, programVersion, uint32(1)},
+		ID:   sunrpc.ProcedureID{ProgramNumber: programNumber, ProgramVersion: programVersion, ProcedureNumber: 1},
 		Name: "Arith.Add"}, true)
 	_ = sunrpc.RegisterProcedure(sunrpc.Procedure{
-		ID:   sunrpc.ProcedureID{programNumber, programVersion, uint32(2)},
+		ID:   sunrpc.ProcedureID{ProgramNumber: programNumber, ProgramVersion: programVersion, ProcedureNumber: 2},
 		Name: "Arith.Multiply"}, true)
 
 	sunrpc.DumpProcedureRegistry()
 
-	// Get port from portmapper
-	port, err := sunrpc.PmapGetPort("", programNumber, programVersion, sunrpc.IPProtoTCP)
-	if err != nil {
-		log.Fatal("sunrpc.PmapGetPort() failed: ", err)
-	}
-
-	// Connect to server
-	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(int(port)))
-	if err != nil {
-		log.Fatal("net.Dial() failed: ", err)
-	}
-
 	// Get notified on server closes the connection
 	notifyClose := make(chan io.ReadWriteCloser, 5)
 	go func() {
@@ -46,8 +34,14 @@ func main() {
 		}
 	}()
 
-	// Create client using sunrpc codec
-	client := rpc.NewClientWithCodec(sunrpc.NewClientCodec(conn, notifyClose))
+	// Get port from portmapper and connect to server
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := sunrpc.DialProgram(ctx, "", programNumber, programVersion,
+		&sunrpc.DialOptions{NotifyClose: notifyClose})
+	if err != nil {
+		log.Fatal("sunrpc.DialProgram() failed: ", err)
+	}
 
 	// Remote function's arguments and results placeholder
 	args := Args{7, 8}
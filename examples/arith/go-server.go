@@ -1,52 +1,54 @@
 package main
 
 import (
+	"context"
 	"io"
 	"log"
 	"net"
 	"net/rpc"
-	"strconv"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/prashanthpai/sunrpc"
 )
 
-const (
-	// This server listens on this port
-	// You can change this and portmapper will take care of telling
-	// the client about it.
-	port = 49999
-)
+// arithProgram describes the Arith program to the portmapper
+type arithProgram struct{}
+
+func (arithProgram) Name() string    { return "Arith" }
+func (arithProgram) Number() uint32  { return 12345 }
+func (arithProgram) Version() uint32 { return 1 }
+
+func (p arithProgram) Procedures() []sunrpc.Procedure {
+	return []sunrpc.Procedure{
+		{ID: sunrpc.ProcedureID{ProgramNumber: p.Number(), ProgramVersion: p.Version(), ProcedureNumber: 1}, Name: "Arith.Add"},
+		{ID: sunrpc.ProcedureID{ProgramNumber: p.Number(), ProgramVersion: p.Version(), ProcedureNumber: 2}, Name: "Arith.Multiply"},
+	}
+}
 
 func main() {
 	server := rpc.NewServer()
 	arith := new(Arith)
 	server.Register(arith)
 
-	programNumber := uint32(12345)
-	programVersion := uint32(1)
-
-	_ = sunrpc.RegisterProcedure(sunrpc.Procedure{
-		ID:   sunrpc.ProcedureID{programNumber, programVersion, uint32(1)},
-		Name: "Arith.Add"}, true)
-	_ = sunrpc.RegisterProcedure(sunrpc.Procedure{
-		ID:   sunrpc.ProcedureID{programNumber, programVersion, uint32(2)},
-		Name: "Arith.Multiply"}, true)
+	program := arithProgram{}
+	for _, procedure := range program.Procedures() {
+		_ = sunrpc.RegisterProcedure(procedure, true)
+	}
 
 	sunrpc.DumpProcedureRegistry()
 
-	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
-	if err != nil {
-		log.Fatal("net.Listen() failed: ", err)
-	}
+	// Unregister from portmapper on exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Tell portmapper about it
-	_, err = sunrpc.PmapUnset(programNumber, programVersion)
-	if err != nil {
-		log.Fatal("sunrpc.PmapUnset() failed: ", err)
-	}
-	_, err = sunrpc.PmapSet(programNumber, programVersion, sunrpc.IPProtoTCP, uint32(port))
+	// Listen on an ephemeral port and tell portmapper about it. The
+	// portmapper will take care of telling the client about the port.
+	listener, registration, err := new(sunrpc.Registrar).ListenAndRegister(ctx, "tcp", "127.0.0.1:0", program)
 	if err != nil {
-		log.Fatal("sunrpc.PmapSet() failed: ", err)
+		log.Fatal("sunrpc.ListenAndRegister() failed: ", err)
 	}
 
 	notifyClose := make(chan io.ReadWriteCloser, 5)
@@ -57,13 +59,23 @@ func main() {
 		}
 	}()
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Fatal("listener.Accept() failed: ", err)
+	// Use sunrpc's codec to handle incoming client connections
+	rpcServer := sunrpc.NewServer(server, notifyClose)
+
+	// Once unregistered, let calls in progress finish before exiting
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-registration.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := rpcServer.Shutdown(shutdownCtx); err != nil {
+			log.Print("Shutdown() failed: ", err)
 		}
-		log.Printf("Client %s connected", conn.RemoteAddr().String())
-		// Use sunrpc's codec to handle incoming client connections
-		go server.ServeCodec(sunrpc.NewServerCodec(conn, notifyClose))
+	}()
+
+	if err := rpcServer.Serve(listener); err != sunrpc.ErrServerClosed {
+		log.Fatal("Serve() failed: ", err)
 	}
+	<-shutdownDone
 }
@@ -0,0 +1,915 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+/*
+From RFC 2203:
+    RPCSEC_GSS is a security flavor which sits "on top" of the GSS-API.
+    ...
+    There are two phases to using RPCSEC_GSS: context creation, and RPC
+    data exchange.  There is also a context destruction phase, to remove
+    the context.
+*/
+
+// gssProc is the RPCSEC_GSS control procedure of a call.
+type gssProc uint32
+
+const (
+	gssProcData         gssProc = 0
+	gssProcInit         gssProc = 1
+	gssProcContinueInit gssProc = 2
+	gssProcDestroy      gssProc = 3
+)
+
+// GSSService is the protection applied to the args and results of calls
+// made using RPCSEC_GSS.
+type GSSService uint32
+
+const (
+	// GSSServiceNone authenticates the caller and the call header only
+	GSSServiceNone GSSService = 1
+	// GSSServiceIntegrity also protects args and results from tampering
+	GSSServiceIntegrity GSSService = 2
+	// GSSServicePrivacy also encrypts args and results
+	GSSServicePrivacy GSSService = 3
+)
+
+const (
+	rpcsecGSSVersion = 1
+
+	// Sequence numbers must be less than this
+	gssMaxSeq = 0x80000000
+
+	// Default size of the sequence window of the server
+	defaultGSSSeqWindow = 128
+
+	// Default limits on the contexts kept by the server. Contexts being
+	// created (half-open) are limited further, as any client can create
+	// them without authenticating.
+	defaultGSSMaxContexts     = 1024
+	defaultGSSMaxHalfOpen     = 64
+	defaultGSSIdleTimeout     = time.Hour
+	defaultGSSHalfOpenTimeout = time.Minute
+
+	// GSS-API major status codes
+	gssComplete       = 0
+	gssContinueNeeded = 1
+	gssFailure        = 13 << 16
+)
+
+// Authentication errors specific to RPCSEC_GSS
+const (
+	// RPCSecGSSCredProblem means no credentials for user
+	RPCSecGSSCredProblem AuthStat = 13
+	// RPCSecGSSCtxProblem means problem with context
+	RPCSecGSSCtxProblem AuthStat = 14
+)
+
+// gssCred is the credential of calls using RPCSEC_GSS.
+type gssCred struct {
+	Version   uint32 // rpcsecGSSVersion
+	Procedure gssProc
+	SeqNum    uint32
+	Service   GSSService
+	Handle    []byte
+}
+
+type gssInitArg struct {
+	Token []byte
+}
+
+type gssInitRes struct {
+	Handle    []byte
+	Major     uint32
+	Minor     uint32
+	SeqWindow uint32
+	Token     []byte
+}
+
+type gssIntegData struct {
+	Body     []byte // sequence number followed by args or result
+	Checksum []byte
+}
+
+type gssPrivData struct {
+	Body []byte // wrapped sequence number followed by args or result
+}
+
+// GSSContext is an established GSS-API security context.
+type GSSContext interface {
+	// GetMIC returns a message integrity code for message.
+	GetMIC(message []byte) ([]byte, error)
+	// VerifyMIC checks that mic is the message integrity code for message.
+	VerifyMIC(message, mic []byte) error
+	// Wrap returns message protected for integrity and confidentiality.
+	Wrap(message []byte) ([]byte, error)
+	// Unwrap returns the message protected by Wrap.
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// GSSClientContext is a security context being established by the
+// initiator (client).
+type GSSClientContext interface {
+	GSSContext
+	// InitSecContext consumes the token received from the server, nil at
+	// first, and returns the token to be sent to the server, if any. It
+	// returns true once the context is established on the client side.
+	InitSecContext(inputToken []byte) (outputToken []byte, established bool, err error)
+}
+
+// GSSServerContext is a security context being established by the acceptor
+// (server).
+type GSSServerContext interface {
+	GSSContext
+	// AcceptSecContext consumes the token received from the client and
+	// returns the token to be sent back, if any. It returns true once the
+	// context is established.
+	AcceptSecContext(inputToken []byte) (outputToken []byte, established bool, err error)
+	// Principal returns the name of the authenticated client.
+	Principal() string
+}
+
+// GSSContextExpiry is implemented by server contexts that expire, such as
+// Kerberos contexts which last as long as the ticket of the client. Expired
+// contexts are dropped by GSSServer.
+type GSSContextExpiry interface {
+	// Expiry returns the time the context expires at, or the zero time if
+	// it doesn't expire.
+	Expiry() time.Time
+}
+
+// GSSMechanism is a GSS-API mechanism, such as Kerberos V5, which creates
+// security contexts for RPCSEC_GSS.
+type GSSMechanism interface {
+	// NewClientContext returns a context to authenticate to the service
+	// named target.
+	NewClientContext(target string) (GSSClientContext, error)
+	// NewServerContext returns a context to accept a client.
+	NewServerContext() (GSSServerContext, error)
+}
+
+func gssSeqBytes(seqNum uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, seqNum)
+	return b
+}
+
+// gssWrap protects data (args or result) as required by service.
+func gssWrap(context GSSContext, service GSSService, seqNum uint32, data []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+	body := append(gssSeqBytes(seqNum), data...)
+
+	switch service {
+	case GSSServiceIntegrity:
+		checksum, err := context.GetMIC(body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := xdr.Marshal(&buf, &gssIntegData{body, checksum}); err != nil {
+			return nil, err
+		}
+	case GSSServicePrivacy:
+		wrapped, err := context.Wrap(body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := xdr.Marshal(&buf, &gssPrivData{wrapped}); err != nil {
+			return nil, err
+		}
+	default:
+		return data, nil
+	}
+
+	return buf.Bytes(), nil
+}
+
+// gssUnwrap returns the data protected by gssWrap after checking that it
+// belongs to the call with the sequence number specified.
+func gssUnwrap(context GSSContext, service GSSService, seqNum uint32, protected []byte) ([]byte, error) {
+
+	var body []byte
+	reader := bytes.NewReader(protected)
+
+	switch service {
+	case GSSServiceIntegrity:
+		var integ gssIntegData
+		if _, err := xdr.Unmarshal(reader, &integ); err != nil {
+			return nil, err
+		}
+		if err := context.VerifyMIC(integ.Body, integ.Checksum); err != nil {
+			return nil, ErrGarbageArgs
+		}
+		body = integ.Body
+	case GSSServicePrivacy:
+		var priv gssPrivData
+		if _, err := xdr.Unmarshal(reader, &priv); err != nil {
+			return nil, err
+		}
+		var err error
+		if body, err = context.Unwrap(priv.Body); err != nil {
+			return nil, ErrGarbageArgs
+		}
+	default:
+		return protected, nil
+	}
+
+	if len(body) < 4 || binary.BigEndian.Uint32(body) != seqNum {
+		return nil, ErrGarbageArgs
+	}
+
+	return body[4:], nil
+}
+
+// GSSClientAuth authenticates calls made by a client using an RPCSEC_GSS
+// context established with the server. Pass it to NewClientCodec using
+// WithAuth. The context is established again when the server drops it.
+type GSSClientAuth struct {
+	mechanism GSSMechanism
+	target    string
+	service   GSSService
+	program   ProcedureID // program and version the context was created with
+
+	mutex   sync.Mutex // protects the fields below
+	context GSSClientContext
+	handle  []byte
+	window  uint32
+	seqNum  uint32
+	stale   bool // dropped by the server, to be established again
+}
+
+// NewGSSClientAuth establishes an RPCSEC_GSS context with the server on
+// conn, on which the program and version specified is served, using a
+// security context of mechanism for the service named target. Calls made
+// with the context are protected as specified by service. The context
+// creation calls are made synchronously, so conn must not be in use.
+func NewGSSClientAuth(conn io.ReadWriter, mechanism GSSMechanism, target string, programNumber, programVersion uint32,
+	service GSSService) (*GSSClientAuth, error) {
+
+	auth := &GSSClientAuth{
+		mechanism: mechanism,
+		target:    target,
+		service:   service,
+		program:   ProcedureID{programNumber, programVersion, 0},
+	}
+
+	exchange := func(xid uint32, payload []byte) ([]byte, error) {
+		if _, err := WriteFullRecord(conn, payload); err != nil {
+			return nil, err
+		}
+		for {
+			record, err := ReadFullRecord(conn)
+			if err != nil {
+				return nil, err
+			}
+			if len(record) >= 4 && binary.BigEndian.Uint32(record) == xid {
+				return record, nil
+			}
+		}
+	}
+	if err := auth.establish(exchange, remoteAddr(conn)); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// establish creates a new context with the server, at addr, making the
+// calls using exchange. The context is used once established.
+func (a *GSSClientAuth) establish(exchange authExchange, addr net.Addr) error {
+
+	context, err := a.mechanism.NewClientContext(a.target)
+	if err != nil {
+		return err
+	}
+
+	token, established, err := context.InitSecContext(nil)
+	if err != nil {
+		return err
+	}
+
+	var handle []byte
+	proc := gssProcInit
+	xid := rand.Uint32()
+	for {
+		xid++
+		call := &gssCall{auth: a, context: context, handle: handle, proc: proc}
+		payload, err := encodeAuthCall(xid, a.program, &gssInitArg{token}, call)
+		if err != nil {
+			return err
+		}
+		record, err := exchange(xid, payload)
+		if err != nil {
+			return err
+		}
+
+		var reply RPCMsg
+		reader := bytes.NewReader(record)
+		if _, err := xdr.Unmarshal(reader, &reply); err != nil {
+			return err
+		}
+		if err := replyError(&reply, a.program, addr); err != nil {
+			return err
+		}
+
+		var res gssInitRes
+		if _, err := xdr.Unmarshal(reader, &res); err != nil {
+			return err
+		}
+		handle = res.Handle
+
+		switch res.Major {
+		case gssComplete:
+			if !established {
+				if _, established, err = context.InitSecContext(res.Token); err != nil {
+					return err
+				}
+				if !established {
+					return ErrGSSContextFailed
+				}
+			}
+			// The server proves itself with the MIC of the window
+			err := context.VerifyMIC(gssSeqBytes(res.SeqWindow), reply.RBody.Areply.Verf.Body)
+			if err != nil || reply.RBody.Areply.Verf.Flavor != RPCsecGss {
+				return ErrInvalidReplyVerifier
+			}
+
+			a.mutex.Lock()
+			a.context = context
+			a.handle = handle
+			a.window = res.SeqWindow
+			a.seqNum = 0
+			a.stale = false
+			a.mutex.Unlock()
+			return nil
+		case gssContinueNeeded:
+			if established {
+				return ErrGSSContextFailed
+			}
+			if token, established, err = context.InitSecContext(res.Token); err != nil {
+				return err
+			}
+			proc = gssProcContinueInit
+		default:
+			return ErrGSSContextFailed
+		}
+	}
+}
+
+// refresh establishes a new context if the server dropped the current one.
+func (a *GSSClientAuth) refresh(exchange authExchange, addr net.Addr) error {
+	a.mutex.Lock()
+	stale := a.stale
+	a.mutex.Unlock()
+
+	if !stale {
+		return nil
+	}
+	return a.establish(exchange, addr)
+}
+
+// Window returns the size of the sequence window of the server. Calls that
+// are outstanding for longer than it takes the client to make as many other
+// calls are dropped by the server.
+func (a *GSSClientAuth) Window() uint32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.window
+}
+
+// NewCall returns the authentication of the next call, which is also
+// protected as required by the service of the context. Calls can't be
+// retransmitted with RPCSEC_GSS, so it's meant for stream transports.
+func (a *GSSClientAuth) NewCall() (AuthCall, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.seqNum >= gssMaxSeq {
+		return nil, ErrGSSContextFailed
+	}
+	call := &gssCall{auth: a, context: a.context, handle: a.handle, proc: gssProcData, seqNum: a.seqNum}
+	a.seqNum++
+
+	return call, nil
+}
+
+func (a *GSSClientAuth) destroyCall() ([]byte, error) {
+	call, err := a.NewCall()
+	if err != nil {
+		return nil, err
+	}
+	call.(*gssCall).proc = gssProcDestroy
+	return encodeAuthCall(rand.Uint32(), a.program, nil, call)
+}
+
+// gssCall is the authentication of a single call made using RPCSEC_GSS.
+type gssCall struct {
+	auth    *GSSClientAuth
+	context GSSClientContext // context and handle the call is made with
+	handle  []byte
+	proc    gssProc
+	seqNum  uint32
+}
+
+func (c *gssCall) Credential() OpaqueAuth {
+	var buf bytes.Buffer
+	cred := gssCred{rpcsecGSSVersion, c.proc, c.seqNum, c.auth.service, c.handle}
+	_, _ = xdr.Marshal(&buf, &cred)
+	return OpaqueAuth{Flavor: RPCsecGss, Body: buf.Bytes()}
+}
+
+func (c *gssCall) Verifier(header []byte) (OpaqueAuth, error) {
+	if c.proc == gssProcInit || c.proc == gssProcContinueInit {
+		return OpaqueAuth{Flavor: AuthNone}, nil
+	}
+
+	mic, err := c.context.GetMIC(header)
+	if err != nil {
+		return OpaqueAuth{}, err
+	}
+	return OpaqueAuth{Flavor: RPCsecGss, Body: mic}, nil
+}
+
+func (c *gssCall) wrapArgs(args []byte) ([]byte, error) {
+	if c.proc != gssProcData {
+		return args, nil
+	}
+	return gssWrap(c.context, c.auth.service, c.seqNum, args)
+}
+
+func (c *gssCall) ValidateReply(verf OpaqueAuth) error {
+	if verf.Flavor != RPCsecGss || c.context.VerifyMIC(gssSeqBytes(c.seqNum), verf.Body) != nil {
+		return ErrInvalidReplyVerifier
+	}
+	return nil
+}
+
+func (c *gssCall) unwrapResult(result []byte) ([]byte, error) {
+	return gssUnwrap(c.context, c.auth.service, c.seqNum, result)
+}
+
+// Refresh marks the context to be established again if the server dropped
+// it (RFC 2203 section 5.3.3.3), unless that was done already since the call
+// was made. The call is then made once more with the new context.
+func (c *gssCall) Refresh(stat AuthStat) bool {
+	if stat != RPCSecGSSCredProblem && stat != RPCSecGSSCtxProblem {
+		return false
+	}
+
+	c.auth.mutex.Lock()
+	defer c.auth.mutex.Unlock()
+
+	if bytes.Equal(c.handle, c.auth.handle) {
+		c.auth.stale = true
+	}
+	return true
+}
+
+// GSSServer accepts RPCSEC_GSS contexts from clients and authenticates calls
+// made using them. A GSSServer can be shared by the server codecs of several
+// connections, to which it is passed using WithGSSServer, but a context is
+// only meant to be used on the connection it was created on.
+//
+// The number of contexts kept is bounded, the least recently used being
+// dropped first. Contexts are also dropped once idle for too long, once
+// expired (see GSSContextExpiry) and once the connection they were created
+// on is closed. Clients create a new context when theirs is dropped.
+type GSSServer struct {
+	mechanism       GSSMechanism
+	window          uint32
+	maxContexts     int
+	maxHalfOpen     int
+	idleTimeout     time.Duration
+	halfOpenTimeout time.Duration
+	now             func() time.Time
+
+	mutex       sync.Mutex                   // protects the fields below
+	contexts    map[string]*gssServerContext // by handle
+	halfOpen    *list.List                   // of contexts being created, most recently used first
+	established *list.List                   // of established contexts, most recently used first
+	nextHandle  uint64
+}
+
+// gssServerContext is a context created by a client.
+type gssServerContext struct {
+	context GSSServerContext
+
+	// Protected by the mutex of GSSServer
+	handle   string
+	owner    interface{}   // server codec of the connection it was created on
+	list     *list.List    // list of GSSServer the context is on
+	elem     *list.Element // nil once dropped
+	lastUsed time.Time
+
+	mutex       sync.Mutex // protects established and the sequence window
+	established bool
+	highest     uint32 // highest sequence number seen
+	seen        []bool // seen[n % window] is set if n was seen
+	anySeen     bool
+}
+
+// GSSServerOption configures optional behaviour of a GSSServer.
+type GSSServerOption func(*GSSServer)
+
+// WithGSSContextLimit sets the maximum number of contexts kept by the
+// server. It defaults to 1024.
+func WithGSSContextLimit(max int) GSSServerOption {
+	return func(s *GSSServer) {
+		s.maxContexts = max
+	}
+}
+
+// WithGSSIdleTimeout sets how long an established context is kept without
+// being used. It defaults to an hour.
+func WithGSSIdleTimeout(timeout time.Duration) GSSServerOption {
+	return func(s *GSSServer) {
+		s.idleTimeout = timeout
+	}
+}
+
+// NewGSSServer returns a GSSServer which accepts contexts created by
+// mechanism.
+func NewGSSServer(mechanism GSSMechanism, opts ...GSSServerOption) *GSSServer {
+	s := &GSSServer{
+		mechanism:       mechanism,
+		window:          defaultGSSSeqWindow,
+		maxContexts:     defaultGSSMaxContexts,
+		idleTimeout:     defaultGSSIdleTimeout,
+		halfOpenTimeout: defaultGSSHalfOpenTimeout,
+		now:             time.Now,
+		contexts:        make(map[string]*gssServerContext),
+		halfOpen:        list.New(),
+		established:     list.New(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.maxContexts < 1 {
+		s.maxContexts = 1
+	}
+	s.maxHalfOpen = defaultGSSMaxHalfOpen
+	if s.maxHalfOpen > s.maxContexts {
+		s.maxHalfOpen = s.maxContexts
+	}
+	return s
+}
+
+// WithGSSServer makes the server codec accept calls using the RPCSEC_GSS
+// flavor, authenticated by server.
+func WithGSSServer(server *GSSServer) ServerOption {
+	return func(c *serverCodec) {
+		c.gss = server
+	}
+}
+
+// context returns the context with the handle specified, or nil if there's
+// none or it has expired.
+func (s *GSSServer) context(handle []byte) *gssServerContext {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	context := s.contexts[string(handle)]
+	if context == nil {
+		return nil
+	}
+
+	now := s.now()
+	if s.expired(context, now) {
+		s.remove(context)
+		return nil
+	}
+	context.lastUsed = now
+	context.list.MoveToFront(context.elem)
+
+	return context
+}
+
+// add keeps a new context created on the connection of owner, dropping
+// expired and least recently used contexts to make room. s.mutex must be
+// held.
+func (s *GSSServer) add(context *gssServerContext, owner interface{}) {
+
+	now := s.now()
+	for _, l := range []*list.List{s.halfOpen, s.established} {
+		for elem := l.Back(); elem != nil; elem = l.Back() {
+			oldest := elem.Value.(*gssServerContext)
+			if !s.expired(oldest, now) {
+				break
+			}
+			s.remove(oldest)
+		}
+	}
+
+	if s.halfOpen.Len() >= s.maxHalfOpen {
+		s.remove(s.halfOpen.Back().Value.(*gssServerContext))
+	}
+	if len(s.contexts) >= s.maxContexts {
+		if s.halfOpen.Len() > 0 {
+			s.remove(s.halfOpen.Back().Value.(*gssServerContext))
+		} else {
+			s.remove(s.established.Back().Value.(*gssServerContext))
+		}
+	}
+
+	s.nextHandle++
+	context.handle = strconv.FormatUint(s.nextHandle, 16)
+	context.owner = owner
+	context.lastUsed = now
+	context.list = s.halfOpen
+	context.elem = s.halfOpen.PushFront(context)
+	s.contexts[context.handle] = context
+}
+
+// establish moves the context to the established contexts.
+func (s *GSSServer) establish(context *gssServerContext) {
+	s.mutex.Lock()
+	if context.elem != nil && context.list == s.halfOpen {
+		s.halfOpen.Remove(context.elem)
+		context.list = s.established
+		context.elem = s.established.PushFront(context)
+	}
+	s.mutex.Unlock()
+
+	context.mutex.Lock()
+	context.established = true
+	context.mutex.Unlock()
+}
+
+// expired returns true if the context is to be dropped. s.mutex must be
+// held.
+func (s *GSSServer) expired(context *gssServerContext, now time.Time) bool {
+
+	timeout := s.idleTimeout
+	if context.list == s.halfOpen {
+		timeout = s.halfOpenTimeout
+	}
+	if timeout > 0 && now.Sub(context.lastUsed) >= timeout {
+		return true
+	}
+
+	if expiry, ok := context.context.(GSSContextExpiry); ok {
+		if at := expiry.Expiry(); !at.IsZero() && !now.Before(at) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove drops the context. s.mutex must be held.
+func (s *GSSServer) remove(context *gssServerContext) {
+	if context.elem == nil {
+		return
+	}
+	context.list.Remove(context.elem)
+	context.elem = nil
+	delete(s.contexts, context.handle)
+}
+
+// dropOwner drops the contexts created on the connection of owner, which is
+// closed.
+func (s *GSSServer) dropOwner(owner interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, context := range s.contexts {
+		if context.owner == owner {
+			s.remove(context)
+		}
+	}
+}
+
+func (c *gssServerContext) isEstablished() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.established
+}
+
+/*
+From RFC 2203:
+    The server maintains a window of "seq_window" sequence numbers,
+    starting with the last sequence number seen and extending backwards.
+    If a sequence number higher than the last number seen is received,
+    the window is moved forward to the new sequence number.  If the last
+    sequence number seen is N, the server is prepared to receive requests
+    with sequence numbers in the range N through (N - seq_window + 1),
+    both inclusive.  If the sequence number received falls below this
+    range, it is silently discarded.  The server is expected to remember
+    which sequence numbers in this range it has seen.  If it sees a
+    sequence number more than once, it silently discards it.
+*/
+
+// checkSeq returns false if the call with the sequence number specified is
+// to be silently discarded.
+func (c *gssServerContext) checkSeq(seqNum uint32) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	window := uint32(len(c.seen))
+	switch {
+	case !c.anySeen || seqNum > c.highest:
+		if !c.anySeen || seqNum-c.highest >= window {
+			c.seen = make([]bool, window)
+		} else {
+			for n := c.highest + 1; n < seqNum; n++ {
+				c.seen[n%window] = false
+			}
+		}
+		c.highest = seqNum
+		c.anySeen = true
+	case c.highest-seqNum >= window:
+		return false
+	case c.seen[seqNum%window]:
+		return false
+	}
+
+	c.seen[seqNum%window] = true
+	return true
+}
+
+// gssServerCall is the authentication of a call accepted by GSSServer,
+// which also protects the reply to it.
+type gssServerCall struct {
+	context *gssServerContext
+	service GSSService
+	seqNum  uint32
+}
+
+func (c *gssServerCall) replyVerifier() (OpaqueAuth, error) {
+	mic, err := c.context.context.GetMIC(gssSeqBytes(c.seqNum))
+	if err != nil {
+		return OpaqueAuth{}, err
+	}
+	return OpaqueAuth{Flavor: RPCsecGss, Body: mic}, nil
+}
+
+func (c *gssServerCall) wrapResult(result []byte) ([]byte, error) {
+	return gssWrap(c.context.context, c.service, c.seqNum, result)
+}
+
+// gssAcceptResult is the outcome of GSSServer.accept for a call.
+type gssAcceptResult struct {
+	reply   []byte         // reply to be sent right away, if any
+	discard bool           // call is to be silently discarded
+	call    *gssServerCall // authentication of a data call
+	args    []byte         // unprotected args of a data call
+	info    CallInfo
+}
+
+// accept authenticates a call made using RPCSEC_GSS on the connection of
+// owner. The header is the marshalled call up to and including the
+// credential and body is the (protected) args of the call.
+func (s *GSSServer) accept(call *RPCMsg, header []byte, body []byte, owner interface{}) (*gssAcceptResult, error) {
+
+	result := new(gssAcceptResult)
+	authError := func(stat AuthStat) (*gssAcceptResult, error) {
+		var err error
+		result.reply, err = encodeAuthErrorReply(call.Xid, stat)
+		return result, err
+	}
+
+	var cred gssCred
+	if _, err := xdr.Unmarshal(bytes.NewReader(call.CBody.Cred.Body), &cred); err != nil {
+		return authError(AuthBadcred)
+	}
+	if cred.Version != rpcsecGSSVersion {
+		return authError(AuthBadcred)
+	}
+
+	switch cred.Procedure {
+	case gssProcInit, gssProcContinueInit:
+		return s.acceptInit(call, &cred, body, owner)
+	case gssProcData, gssProcDestroy:
+	default:
+		return authError(AuthBadcred)
+	}
+
+	context := s.context(cred.Handle)
+	if context == nil || !context.isEstablished() {
+		return authError(RPCSecGSSCredProblem)
+	}
+	if cred.SeqNum >= gssMaxSeq {
+		return authError(RPCSecGSSCtxProblem)
+	}
+
+	verf := call.CBody.Verf
+	if verf.Flavor != RPCsecGss || context.context.VerifyMIC(header, verf.Body) != nil {
+		return authError(RPCSecGSSCredProblem)
+	}
+
+	if !context.checkSeq(cred.SeqNum) {
+		result.discard = true
+		return result, nil
+	}
+
+	result.call = &gssServerCall{context, cred.Service, cred.SeqNum}
+	result.info.Principal = context.context.Principal()
+	result.info.GSSService = cred.Service
+
+	if cred.Procedure == gssProcDestroy {
+		s.mutex.Lock()
+		s.remove(context)
+		s.mutex.Unlock()
+
+		var err error
+		result.reply, err = encodeVerifiedReply(call.Xid, Success, nil, result.call)
+		return result, err
+	}
+
+	args, err := gssUnwrap(context.context, cred.Service, cred.SeqNum, body)
+	if err != nil {
+		// Args that fail integrity checks are discarded
+		result.discard = true
+		return result, nil
+	}
+	result.args = args
+
+	return result, nil
+}
+
+// acceptInit handles the context creation calls.
+func (s *GSSServer) acceptInit(call *RPCMsg, cred *gssCred, body []byte, owner interface{}) (*gssAcceptResult, error) {
+
+	result := new(gssAcceptResult)
+
+	var arg gssInitArg
+	if _, err := xdr.Unmarshal(bytes.NewReader(body), &arg); err != nil {
+		result.reply, err = encodeReply(call.Xid, GarbageArgs, nil)
+		return result, err
+	}
+
+	var context *gssServerContext
+	if cred.Procedure == gssProcInit {
+		serverContext, err := s.mechanism.NewServerContext()
+		if err != nil {
+			return nil, err
+		}
+		context = &gssServerContext{context: serverContext, seen: make([]bool, s.window)}
+		s.mutex.Lock()
+		s.add(context, owner)
+		s.mutex.Unlock()
+	} else {
+		if context = s.context(cred.Handle); context == nil || context.isEstablished() {
+			var err error
+			result.reply, err = encodeAuthErrorReply(call.Xid, RPCSecGSSCredProblem)
+			return result, err
+		}
+	}
+
+	res := gssInitRes{Handle: cred.Handle, SeqWindow: s.window}
+	if cred.Procedure == gssProcInit {
+		res.Handle = []byte(context.handle)
+	}
+	verf := OpaqueAuth{Flavor: AuthNone}
+
+	token, established, err := context.context.AcceptSecContext(arg.Token)
+	res.Token = token
+	switch {
+	case err != nil:
+		res.Major = gssFailure
+		s.mutex.Lock()
+		s.remove(context)
+		s.mutex.Unlock()
+	case established:
+		res.Major = gssComplete
+		s.establish(context)
+		mic, err := context.context.GetMIC(gssSeqBytes(s.window))
+		if err != nil {
+			return nil, err
+		}
+		verf = OpaqueAuth{Flavor: RPCsecGss, Body: mic}
+	default:
+		res.Major = gssContinueNeeded
+	}
+
+	result.reply, err = encodeVerifiedReply(call.Xid, Success, &res, staticReplyAuth(verf))
+	return result, err
+}
+
+// staticReplyAuth is a reply authentication with a fixed verifier and no
+// protection of the result.
+type staticReplyAuth OpaqueAuth
+
+func (a staticReplyAuth) replyVerifier() (OpaqueAuth, error) {
+	return OpaqueAuth(a), nil
+}
+
+func (a staticReplyAuth) wrapResult(result []byte) ([]byte, error) {
+	return result, nil
+}
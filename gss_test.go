@@ -0,0 +1,524 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+var gssTestProc = ProcedureID{ProgramNumber: 66600, ProgramVersion: 1, ProcedureNumber: 1}
+
+type GSSTestArgs struct {
+	A    int32
+	info *CallInfo
+}
+
+func (a *GSSTestArgs) SetCallInfo(info *CallInfo) { a.info = info }
+
+type GSSTest struct{}
+
+// Who returns the principal and service of the caller, and the args.
+func (GSSTest) Who(args *GSSTestArgs, reply *string) error {
+	*reply = fmt.Sprintf("%s/%d/%d", args.info.Principal, args.info.GSSService, args.A)
+	return nil
+}
+
+// serveGSS serves GSSTest on one end of a pipe and returns the other end.
+func serveGSS(t *testing.T, gss *GSSServer) net.Conn {
+	t.Helper()
+
+	if err := RegisterProcedure(Procedure{gssTestProc, "GSSTest.Who"}, true); err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.Register(GSSTest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(serverConn, nil, WithGSSServer(gss)))
+	t.Cleanup(func() { clientConn.Close() })
+
+	return clientConn
+}
+
+func TestGSSContextCreation(t *testing.T) {
+	mechanism := &mockGSSMechanism{Key: []byte("secret"), Principal: "alice"}
+	gss := NewGSSServer(mechanism)
+
+	for _, service := range []GSSService{GSSServiceNone, GSSServiceIntegrity, GSSServicePrivacy} {
+		conn := serveGSS(t, gss)
+		auth, err := NewGSSClientAuth(conn, mechanism, "test", gssTestProc.ProgramNumber,
+			gssTestProc.ProgramVersion, service)
+		if err != nil {
+			t.Fatalf("service %d: %v", service, err)
+		}
+		if auth.Window() != defaultGSSSeqWindow {
+			t.Fatalf("got window %d", auth.Window())
+		}
+
+		client := rpc.NewClientWithCodec(NewClientCodec(conn, nil, WithAuth(auth)))
+		for i := 0; i < 3; i++ {
+			var reply string
+			if err := client.Call("GSSTest.Who", &GSSTestArgs{A: int32(i)}, &reply); err != nil {
+				t.Fatalf("service %d: %v", service, err)
+			}
+			if want := fmt.Sprintf("alice/%d/%d", service, i); reply != want {
+				t.Fatalf("got reply %q, want %q", reply, want)
+			}
+		}
+		client.Close()
+	}
+}
+
+func TestGSSContextCreationWrongKey(t *testing.T) {
+	gss := NewGSSServer(&mockGSSMechanism{Key: []byte("secret")})
+	conn := serveGSS(t, gss)
+
+	_, err := NewGSSClientAuth(conn, &mockGSSMechanism{Key: []byte("wrong"), Principal: "eve"}, "test",
+		gssTestProc.ProgramNumber, gssTestProc.ProgramVersion, GSSServiceNone)
+	if err != ErrGSSContextFailed {
+		t.Fatalf("got error %v, want %v", err, ErrGSSContextFailed)
+	}
+	if n := len(gss.contexts); n != 0 {
+		t.Fatalf("%d contexts kept after failure", n)
+	}
+}
+
+func TestGSSCheckSeq(t *testing.T) {
+	context := &gssServerContext{seen: make([]bool, 4)}
+
+	steps := []struct {
+		seqNum uint32
+		ok     bool
+	}{
+		{0, true},
+		{0, false}, // replay
+		{5, true},  // window moves to 2..5
+		{3, true},  // within window, not seen
+		{3, false}, // replay within window
+		{1, false}, // below window
+		{2, true},  // lowest of window
+		{100, true},
+		{5, false},  // far below window
+		{97, true},  // lowest of window
+		{96, false}, // just below window
+		{101, true},
+		{97, false}, // now below window
+	}
+
+	for i, step := range steps {
+		if ok := context.checkSeq(step.seqNum); ok != step.ok {
+			t.Fatalf("step %d: checkSeq(%d) = %v, want %v", i, step.seqNum, ok, step.ok)
+		}
+	}
+}
+
+// gssTestClient establishes a context with s directly, without a connection,
+// and makes calls using it.
+type gssTestClient struct {
+	t      *testing.T
+	server *GSSServer
+	owner  interface{}
+	auth   *GSSClientAuth
+}
+
+func newGSSTestClient(t *testing.T, s *GSSServer, owner interface{}) *gssTestClient {
+	t.Helper()
+
+	mechanism := &mockGSSMechanism{Key: []byte("secret"), Principal: "alice"}
+	context, _ := mechanism.NewClientContext("test")
+	c := &gssTestClient{t: t, server: s, owner: owner, auth: &GSSClientAuth{
+		context: context,
+		service: GSSServiceIntegrity,
+		program: gssTestProc,
+	}}
+
+	token, _, _ := context.InitSecContext(nil)
+	res := c.init(gssProcInit, token)
+	if res.Major != gssContinueNeeded {
+		t.Fatalf("INIT: got major status %d", res.Major)
+	}
+	c.auth.handle = res.Handle
+	token, _, _ = context.InitSecContext(res.Token)
+	if res := c.init(gssProcContinueInit, token); res.Major != gssComplete {
+		t.Fatalf("CONTINUE_INIT: got major status %d", res.Major)
+	}
+
+	return c
+}
+
+// halfOpen starts creating a context with s and returns its handle.
+func halfOpen(t *testing.T, s *GSSServer, owner interface{}) []byte {
+	t.Helper()
+
+	c := &gssTestClient{t: t, server: s, owner: owner, auth: &GSSClientAuth{program: gssTestProc}}
+	token, _, _ := (&mockGSSClientContext{principal: "mallory"}).InitSecContext(nil)
+	return c.init(gssProcInit, token).Handle
+}
+
+func (c *gssTestClient) init(proc gssProc, token []byte) gssInitRes {
+	c.t.Helper()
+
+	payload, err := encodeAuthCall(1, gssTestProc, &gssInitArg{token}, c.newCall(proc, 0))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	result := c.accept(payload)
+
+	reader := bytes.NewReader(result.reply)
+	var reply RPCMsg
+	if _, err := xdr.Unmarshal(reader, &reply); err != nil {
+		c.t.Fatal(err)
+	}
+	if err := checkReplyForErr(&reply); err != nil {
+		c.t.Fatal(err)
+	}
+	var res gssInitRes
+	if _, err := xdr.Unmarshal(reader, &res); err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+// newCall returns the authentication of a call using the context of c.
+func (c *gssTestClient) newCall(proc gssProc, seqNum uint32) *gssCall {
+	return &gssCall{auth: c.auth, context: c.auth.context, handle: c.auth.handle, proc: proc, seqNum: seqNum}
+}
+
+// call makes a call with the sequence number specified, possibly tampered
+// with by tamper, and returns the result of accepting it.
+func (c *gssTestClient) call(proc gssProc, seqNum uint32, tamper func([]byte)) *gssAcceptResult {
+	c.t.Helper()
+	return c.callWith(c.newCall(proc, seqNum), tamper)
+}
+
+func (c *gssTestClient) callWith(call AuthCall, tamper func([]byte)) *gssAcceptResult {
+	c.t.Helper()
+
+	payload, err := encodeAuthCall(1, gssTestProc, &GSSTestArgs{A: 1}, call)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(payload)
+	}
+	return c.accept(payload)
+}
+
+// accept passes the call in payload to the server as the server codec does.
+func (c *gssTestClient) accept(payload []byte) *gssAcceptResult {
+	c.t.Helper()
+
+	reader := bytes.NewReader(payload)
+	var call RPCMsg
+	if _, err := xdr.Unmarshal(reader, &call); err != nil {
+		c.t.Fatal(err)
+	}
+	headerSize := len(payload) - reader.Len() - opaqueAuthSize(call.CBody.Verf)
+	result, err := c.server.accept(&call, payload[:headerSize], payload[len(payload)-reader.Len():], c.owner)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return result
+}
+
+// authStat returns the status of the authentication error reply, or AuthOk
+// if the reply isn't one.
+func authStat(t *testing.T, reply []byte) AuthStat {
+	t.Helper()
+
+	var msg RPCMsg
+	if _, err := xdr.Unmarshal(bytes.NewReader(reply), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.RBody.Stat == MsgDenied && msg.RBody.Rreply.Stat == AuthError {
+		return msg.RBody.Rreply.AuthStat
+	}
+	return AuthOk
+}
+
+func TestGSSSequenceWindow(t *testing.T) {
+	s := NewGSSServer(&mockGSSMechanism{Key: []byte("secret")})
+	c := newGSSTestClient(t, s, nil)
+
+	if result := c.call(gssProcData, 500, nil); result.call == nil {
+		t.Fatal("call not accepted")
+	}
+	if result := c.call(gssProcData, 500, nil); !result.discard {
+		t.Fatal("replayed call not discarded")
+	}
+
+	// Calls below the window are dropped silently
+	result := c.call(gssProcData, 500-defaultGSSSeqWindow, nil)
+	if !result.discard || result.reply != nil {
+		t.Fatal("call below the window not discarded")
+	}
+
+	// Sequence numbers out of range are rejected with an error
+	result = c.call(gssProcData, gssMaxSeq, nil)
+	if result.discard || authStat(t, result.reply) != RPCSecGSSCtxProblem {
+		t.Fatal("call with sequence number out of range not rejected")
+	}
+}
+
+func TestGSSVerifier(t *testing.T) {
+	s := NewGSSServer(&mockGSSMechanism{Key: []byte("secret")})
+	c := newGSSTestClient(t, s, nil)
+
+	result := c.callWith(badVerifierCall{c.newCall(gssProcData, 1)}, nil)
+	if authStat(t, result.reply) != RPCSecGSSCredProblem {
+		t.Fatal("call with invalid verifier not rejected")
+	}
+
+	// Tampered args fail the integrity check and are discarded
+	result = c.call(gssProcData, 2, func(payload []byte) {
+		payload[len(payload)-1] ^= 1
+	})
+	if !result.discard {
+		t.Fatal("call with tampered args not discarded")
+	}
+
+	// The client checks the verifier of the reply
+	call := c.newCall(gssProcData, 3)
+	mic, _ := c.auth.context.GetMIC(gssSeqBytes(3))
+	if err := call.ValidateReply(OpaqueAuth{Flavor: RPCsecGss, Body: mic}); err != nil {
+		t.Fatal(err)
+	}
+	mic[0] ^= 1
+	if err := call.ValidateReply(OpaqueAuth{Flavor: RPCsecGss, Body: mic}); err != ErrInvalidReplyVerifier {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidReplyVerifier)
+	}
+	if err := call.ValidateReply(OpaqueAuth{Flavor: AuthNone}); err != ErrInvalidReplyVerifier {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidReplyVerifier)
+	}
+}
+
+func TestGSSDestroy(t *testing.T) {
+	s := NewGSSServer(&mockGSSMechanism{Key: []byte("secret")})
+	c := newGSSTestClient(t, s, nil)
+
+	result := c.call(gssProcDestroy, 1, nil)
+	if result.reply == nil || authStat(t, result.reply) != AuthOk {
+		t.Fatal("DESTROY not replied to")
+	}
+	if n := len(s.contexts); n != 0 {
+		t.Fatalf("%d contexts kept after DESTROY", n)
+	}
+
+	result = c.call(gssProcData, 2, nil)
+	if authStat(t, result.reply) != RPCSecGSSCredProblem {
+		t.Fatal("call using destroyed context not rejected")
+	}
+}
+
+func TestGSSHalfOpenLimit(t *testing.T) {
+	s := NewGSSServer(&mockGSSMechanism{Key: []byte("secret")})
+
+	// Contexts never established by unauthenticated clients are limited
+	// on their own
+	established := newGSSTestClient(t, s, nil)
+	for i := 0; i < 10*defaultGSSMaxHalfOpen; i++ {
+		halfOpen(t, s, nil)
+	}
+	if n := s.halfOpen.Len(); n != defaultGSSMaxHalfOpen {
+		t.Fatalf("%d half-open contexts kept", n)
+	}
+	if result := established.call(gssProcData, 1, nil); result.call == nil {
+		t.Fatal("established context evicted by half-open ones")
+	}
+}
+
+func TestGSSContextLimit(t *testing.T) {
+	s := NewGSSServer(&mockGSSMechanism{Key: []byte("secret")}, WithGSSContextLimit(2))
+
+	first := newGSSTestClient(t, s, nil)
+	second := newGSSTestClient(t, s, nil)
+	first.call(gssProcData, 1, nil)
+
+	// The least recently used context is evicted to make room
+	newGSSTestClient(t, s, nil)
+	if n := len(s.contexts); n != 2 {
+		t.Fatalf("%d contexts kept", n)
+	}
+	if result := first.call(gssProcData, 2, nil); result.call == nil {
+		t.Fatal("recently used context evicted")
+	}
+	if result := second.call(gssProcData, 1, nil); authStat(t, result.reply) != RPCSecGSSCredProblem {
+		t.Fatal("least recently used context kept")
+	}
+}
+
+func TestGSSContextExpiry(t *testing.T) {
+	now := time.Now()
+	s := NewGSSServer(&mockGSSMechanism{Key: []byte("secret")}, WithGSSIdleTimeout(time.Hour))
+	s.now = func() time.Time { return now }
+
+	c := newGSSTestClient(t, s, nil)
+	handle := halfOpen(t, s, nil)
+
+	now = now.Add(defaultGSSHalfOpenTimeout)
+	if s.context(handle) != nil {
+		t.Fatal("idle half-open context kept")
+	}
+	if result := c.call(gssProcData, 1, nil); result.call == nil {
+		t.Fatal("established context dropped")
+	}
+
+	now = now.Add(time.Hour)
+	if result := c.call(gssProcData, 2, nil); authStat(t, result.reply) != RPCSecGSSCredProblem {
+		t.Fatal("idle context kept")
+	}
+
+	// Contexts of the mechanism expire on their own
+	s = NewGSSServer(expiringGSSMechanism{&mockGSSMechanism{Key: []byte("secret")}, now.Add(time.Second)})
+	s.now = func() time.Time { return now }
+	c = newGSSTestClient(t, s, nil)
+	if result := c.call(gssProcData, 1, nil); result.call == nil {
+		t.Fatal("context expired early")
+	}
+	now = now.Add(time.Second)
+	if result := c.call(gssProcData, 2, nil); authStat(t, result.reply) != RPCSecGSSCredProblem {
+		t.Fatal("expired context kept")
+	}
+}
+
+func TestGSSContextsDroppedWithConnection(t *testing.T) {
+	mechanism := &mockGSSMechanism{Key: []byte("secret"), Principal: "alice"}
+	gss := NewGSSServer(mechanism)
+
+	conn := serveGSS(t, gss)
+	if _, err := NewGSSClientAuth(conn, mechanism, "test", gssTestProc.ProgramNumber,
+		gssTestProc.ProgramVersion, GSSServiceNone); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gss.contexts); n != 1 {
+		t.Fatalf("%d contexts kept", n)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		gss.mutex.Lock()
+		n := len(gss.contexts)
+		gss.mutex.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("context kept after its connection closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGSSContextRefresh(t *testing.T) {
+	mechanism := &countingGSSMechanism{mockGSSMechanism: &mockGSSMechanism{Key: []byte("secret"), Principal: "alice"}}
+	gss := NewGSSServer(mechanism)
+
+	conn := serveGSS(t, gss)
+	auth, err := NewGSSClientAuth(conn, mechanism, "test", gssTestProc.ProgramNumber,
+		gssTestProc.ProgramVersion, GSSServiceIntegrity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClientWithCodec(NewClientCodec(conn, nil, WithAuth(auth)))
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("GSSTest.Who", &GSSTestArgs{A: 1}, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// Calls made with the dropped context are rejected, then made once
+	// more with a single new context.
+	gss.mutex.Lock()
+	for _, context := range gss.contexts {
+		gss.remove(context)
+	}
+	gss.mutex.Unlock()
+
+	calls := make([]*rpc.Call, 3)
+	for i := range calls {
+		calls[i] = client.Go("GSSTest.Who", &GSSTestArgs{A: int32(i)}, new(string), nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			t.Fatal(call.Error)
+		}
+		if want := fmt.Sprintf("alice/%d/%d", GSSServiceIntegrity, i); *call.Reply.(*string) != want {
+			t.Fatalf("got reply %q, want %q", *call.Reply.(*string), want)
+		}
+	}
+	if n := mechanism.count(); n != 2 {
+		t.Fatalf("%d contexts created, want 2", n)
+	}
+
+	// The context isn't created again when the server rejects the call
+	// for another reason.
+	call, _ := auth.NewCall()
+	if call.Refresh(AuthTooweak) {
+		t.Fatal("call refreshed for a weak credential")
+	}
+}
+
+// countingGSSMechanism counts the server contexts created.
+type countingGSSMechanism struct {
+	*mockGSSMechanism
+	mutex sync.Mutex
+	n     int
+}
+
+func (m *countingGSSMechanism) NewServerContext() (GSSServerContext, error) {
+	m.mutex.Lock()
+	m.n++
+	m.mutex.Unlock()
+	return m.mockGSSMechanism.NewServerContext()
+}
+
+func (m *countingGSSMechanism) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.n
+}
+
+// expiringGSSMechanism creates server contexts expiring at the time
+// specified.
+type expiringGSSMechanism struct {
+	*mockGSSMechanism
+	expiry time.Time
+}
+
+func (m expiringGSSMechanism) NewServerContext() (GSSServerContext, error) {
+	context, err := m.mockGSSMechanism.NewServerContext()
+	return expiringGSSContext{context, m.expiry}, err
+}
+
+type expiringGSSContext struct {
+	GSSServerContext
+	expiry time.Time
+}
+
+func (c expiringGSSContext) Expiry() time.Time { return c.expiry }
+
+// badVerifierCall is a call with an invalid verifier.
+type badVerifierCall struct {
+	*gssCall
+}
+
+func (c badVerifierCall) Verifier(header []byte) (OpaqueAuth, error) {
+	verf, err := c.gssCall.Verifier(header)
+	verf.Body[0] ^= 1
+	return verf, err
+}
@@ -79,6 +79,7 @@ const (
 	AuthKerb                    // Keberos Auth
 	AuthRSA                     // RSA authentication
 	RPCsecGss                   // GSS-based RPC security
+	AuthTLS                     // RPC-with-TLS probe (RFC 9289)
 )
 
 // OpaqueAuth is a structure with AuthFlavor enumeration followed by up to
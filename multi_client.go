@@ -0,0 +1,271 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpreadPolicy selects the connection of a MultiClient a call is made on.
+type SpreadPolicy int
+
+const (
+	// RoundRobin makes calls on each connection in turn
+	RoundRobin SpreadPolicy = iota
+	// LeastOutstanding makes calls on the connection with the fewest calls
+	// awaiting reply
+	LeastOutstanding
+)
+
+const (
+	// Delay before dialing again when replacing a failed connection, which
+	// is doubled on every failed attempt up to the maximum
+	minRedialDelay = 100 * time.Millisecond
+	maxRedialDelay = 5 * time.Second
+)
+
+// MultiClient makes calls to a server over multiple connections, like the
+// nconnect mount option of NFS clients, so that calls aren't limited by a
+// single connection. Calls are spread across the connections as per its
+// SpreadPolicy. A connection that fails is replaced in the background by
+// dialing again; calls in flight on it fail. A MultiClient can be used
+// concurrently, like rpc.Client.
+type MultiClient struct {
+	dial   func() (*rpc.Client, error)
+	policy SpreadPolicy
+	conns  []*multiConn
+	next   uint32 // index of connection to start picking from
+
+	mutex  sync.Mutex // protects closed
+	closed bool
+	done   chan struct{} // closed by Close
+}
+
+// multiConn is a connection of a MultiClient.
+type multiConn struct {
+	mutex       sync.Mutex
+	client      *rpc.Client // nil while being replaced
+	outstanding int32       // calls awaiting reply, updated atomically
+}
+
+// NewMultiClient returns a MultiClient maintaining n connections, each
+// made by calling dial. dial is called again to replace a connection that
+// fails. An error is returned if any of the initial connections can't be
+// made.
+func NewMultiClient(n int, policy SpreadPolicy, dial func() (*rpc.Client, error)) (*MultiClient, error) {
+
+	if n < 1 {
+		n = 1
+	}
+
+	c := &MultiClient{
+		dial:   dial,
+		policy: policy,
+		conns:  make([]*multiConn, n),
+		done:   make(chan struct{}),
+	}
+
+	for i := range c.conns {
+		client, err := dial()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.conns[i] = &multiConn{client: client}
+	}
+
+	return c, nil
+}
+
+// DialMulti connects to a Sun-RPC server at the specified network address
+// over n connections.
+func DialMulti(network, address string, n int, policy SpreadPolicy) (*MultiClient, error) {
+	return NewMultiClient(n, policy, func() (*rpc.Client, error) {
+		return Dial(network, address)
+	})
+}
+
+// Call invokes the named function, waits for it to complete, and returns
+// its error status. See rpc.Client.Call.
+func (c *MultiClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	call := <-c.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1)).Done
+	return call.Error
+}
+
+// Go invokes the function asynchronously. See rpc.Client.Go.
+func (c *MultiClient) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+
+	if done == nil {
+		done = make(chan *rpc.Call, 1)
+	}
+	call := &rpc.Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+
+	conn, client, err := c.pick()
+	if err != nil {
+		call.Error = err
+		call.Done <- call
+		return call
+	}
+
+	atomic.AddInt32(&conn.outstanding, 1)
+	inner := client.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1))
+	go func() {
+		<-inner.Done
+		atomic.AddInt32(&conn.outstanding, -1)
+		if isConnFailure(inner.Error) {
+			c.replace(conn, client)
+		}
+
+		call.Error = inner.Error
+		select {
+		case call.Done <- call:
+		default:
+			// Like net/rpc, don't block if done has no room
+		}
+	}()
+
+	return call
+}
+
+// pick returns the connection to make the next call on.
+func (c *MultiClient) pick() (*multiConn, *rpc.Client, error) {
+
+	if c.isClosed() {
+		return nil, nil, rpc.ErrShutdown
+	}
+
+	start := int(atomic.AddUint32(&c.next, 1) % uint32(len(c.conns)))
+
+	var best *multiConn
+	var bestClient *rpc.Client
+	for i := range c.conns {
+		conn := c.conns[(start+i)%len(c.conns)]
+		conn.mutex.Lock()
+		client := conn.client
+		conn.mutex.Unlock()
+		if client == nil {
+			continue
+		}
+		if c.policy == RoundRobin {
+			return conn, client, nil
+		}
+		if best == nil || atomic.LoadInt32(&conn.outstanding) < atomic.LoadInt32(&best.outstanding) {
+			best, bestClient = conn, client
+		}
+	}
+
+	if best == nil {
+		return nil, nil, ErrNoConnection
+	}
+
+	return best, bestClient, nil
+}
+
+// replace closes the failed client of conn and dials a new one in the
+// background.
+func (c *MultiClient) replace(conn *multiConn, failed *rpc.Client) {
+
+	conn.mutex.Lock()
+	if conn.client != failed {
+		// Already being replaced
+		conn.mutex.Unlock()
+		return
+	}
+	conn.client = nil
+	conn.mutex.Unlock()
+	failed.Close()
+
+	go func() {
+		delay := minRedialDelay
+		for {
+			client, err := c.dial()
+			if err == nil {
+				conn.mutex.Lock()
+				conn.client = client
+				conn.mutex.Unlock()
+				// Close could have missed the new client
+				if c.isClosed() {
+					c.closeConn(conn)
+				}
+				return
+			}
+
+			select {
+			case <-time.After(delay):
+			case <-c.done:
+				return
+			}
+			delay *= 2
+			if delay > maxRedialDelay {
+				delay = maxRedialDelay
+			}
+		}
+	}()
+}
+
+// isConnFailure returns true if the call failed with err because its
+// connection is no longer usable. Failures reported by the server, unknown
+// procedures and errors marshalling args leave the connection usable.
+func isConnFailure(err error) bool {
+	if err == rpc.ErrShutdown || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+func (c *MultiClient) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+func (c *MultiClient) closeConn(conn *multiConn) error {
+	conn.mutex.Lock()
+	client := conn.client
+	conn.client = nil
+	conn.mutex.Unlock()
+
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
+
+// Close closes all connections. Calls in flight fail.
+func (c *MultiClient) Close() error {
+
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return rpc.ErrShutdown
+	}
+	c.closed = true
+	close(c.done)
+	c.mutex.Unlock()
+
+	var err error
+	for _, conn := range c.conns {
+		if conn == nil {
+			continue
+		}
+		if cerr := c.closeConn(conn); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
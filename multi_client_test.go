@@ -0,0 +1,240 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+var multiTestProcs = []Procedure{
+	{ProcedureID{ProgramNumber: 66607, ProgramVersion: 1, ProcedureNumber: 1}, "MultiTest.Who"},
+	{ProcedureID{ProgramNumber: 66607, ProgramVersion: 1, ProcedureNumber: 2}, "MultiTest.Block"},
+	{ProcedureID{ProgramNumber: 66607, ProgramVersion: 1, ProcedureNumber: 3}, "MultiTest.Fail"},
+}
+
+// MultiTest is served on each connection of a multiServer.
+type MultiTest struct {
+	id      int32
+	release chan struct{}
+}
+
+// Who returns the index of the connection the call was made on.
+func (m *MultiTest) Who(args int32, reply *int32) error {
+	*reply = m.id
+	return nil
+}
+
+// Block returns the index of the connection once release is closed.
+func (m *MultiTest) Block(args int32, reply *int32) error {
+	<-m.release
+	*reply = m.id
+	return nil
+}
+
+// Fail always fails.
+func (m *MultiTest) Fail(args int32, reply *int32) error {
+	return errors.New("failed")
+}
+
+// multiServer serves MultiTest on every connection dialed over TCP.
+type multiServer struct {
+	listener net.Listener
+	release  chan struct{}
+
+	mutex sync.Mutex
+	conns []net.Conn // server ends in the order dialed
+}
+
+func newMultiServer(t *testing.T) *multiServer {
+	for _, p := range multiTestProcs {
+		if err := RegisterProcedure(p, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &multiServer{listener: listener, release: make(chan struct{})}
+	t.Cleanup(func() {
+		listener.Close()
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, conn := range s.conns {
+			conn.Close()
+		}
+	})
+
+	return s
+}
+
+func (s *multiServer) dial() (*rpc.Client, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	server := rpc.NewServer()
+	if err := server.Register(&MultiTest{int32(len(s.conns)), s.release}); err != nil {
+		return nil, err
+	}
+
+	clientConn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	serverConn, err := s.listener.Accept()
+	if err != nil {
+		clientConn.Close()
+		return nil, err
+	}
+	s.conns = append(s.conns, serverConn)
+	go server.ServeCodec(NewServerCodec(serverConn, nil))
+
+	return NewClient(clientConn), nil
+}
+
+func (s *multiServer) dialed() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+// kill closes the server end of the connection dialed i-th.
+func (s *multiServer) kill(i int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.conns[i].Close()
+}
+
+func newTestMultiClient(t *testing.T, s *multiServer, n int, policy SpreadPolicy) *MultiClient {
+	c, err := NewMultiClient(n, policy, s.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestMultiClientRoundRobin(t *testing.T) {
+	s := newMultiServer(t)
+	c := newTestMultiClient(t, s, 3, RoundRobin)
+
+	calls := make(map[int32]int)
+	for i := 0; i < 9; i++ {
+		var id int32
+		if err := c.Call("MultiTest.Who", int32(0), &id); err != nil {
+			t.Fatal(err)
+		}
+		calls[id]++
+	}
+
+	for id := int32(0); id < 3; id++ {
+		if calls[id] != 3 {
+			t.Fatalf("calls per connection %v, want 3 each", calls)
+		}
+	}
+}
+
+func TestMultiClientLeastOutstanding(t *testing.T) {
+	s := newMultiServer(t)
+	c := newTestMultiClient(t, s, 2, LeastOutstanding)
+
+	var blocked int32
+	call := c.Go("MultiTest.Block", int32(0), &blocked, nil)
+
+	// Calls avoid the connection with a call awaiting reply
+	var first int32 = -1
+	for i := 0; i < 4; i++ {
+		var id int32
+		if err := c.Call("MultiTest.Who", int32(0), &id); err != nil {
+			t.Fatal(err)
+		}
+		if first < 0 {
+			first = id
+		}
+		if id != first {
+			t.Fatalf("call made on connection %d, then %d", first, id)
+		}
+	}
+
+	close(s.release)
+	if err := (<-call.Done).Error; err != nil {
+		t.Fatal(err)
+	}
+	if blocked == first {
+		t.Fatalf("calls made on connection %d with a call awaiting reply", first)
+	}
+}
+
+func TestMultiClientReplace(t *testing.T) {
+	s := newMultiServer(t)
+	c := newTestMultiClient(t, s, 2, RoundRobin)
+
+	// Failures reported by the server and unknown procedures leave the
+	// connections in place
+	for i := 0; i < 2; i++ {
+		var id int32
+		if _, ok := c.Call("MultiTest.Fail", int32(0), &id).(rpc.ServerError); !ok {
+			t.Fatal("call didn't fail with a server error")
+		}
+		if err := c.Call("MultiTest.Unknown", int32(0), &id); err != ErrProcUnavail {
+			t.Fatalf("got error %v, want %v", err, ErrProcUnavail)
+		}
+	}
+	if n := s.dialed(); n != 2 {
+		t.Fatalf("dialed %d connections, want 2", n)
+	}
+
+	// A lost connection fails the calls made on it and is replaced
+	s.kill(0)
+	var failed bool
+	for i := 0; i < 2; i++ {
+		var id int32
+		if err := c.Call("MultiTest.Who", int32(0), &id); err != nil {
+			if !isConnFailure(err) {
+				t.Fatalf("got error %v for lost connection", err)
+			}
+			failed = true
+		}
+	}
+	if !failed {
+		t.Fatal("no call failed on the lost connection")
+	}
+
+	// The connection dialed third replaces it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var id int32
+		if err := c.Call("MultiTest.Who", int32(0), &id); err != nil {
+			t.Fatal(err)
+		}
+		if id == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lost connection not replaced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Calls are made on both connections again
+	calls := make(map[int32]int)
+	for i := 0; i < 4; i++ {
+		var id int32
+		if err := c.Call("MultiTest.Who", int32(0), &id); err != nil {
+			t.Fatal(err)
+		}
+		calls[id]++
+	}
+	if calls[1] != 2 || calls[2] != 2 {
+		t.Fatalf("calls per connection %v, want 2 on each of 1 and 2", calls)
+	}
+}
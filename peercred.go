@@ -0,0 +1,18 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+// RpcbindSocket is the path of the Unix domain socket on which rpcbind
+// listens for local callers. Use it as PmapClient.Socket.
+const RpcbindSocket = "/run/rpcbind.sock"
+
+// PeerCred is the identity of the process at the other end of a Unix domain
+// socket connection, as verified by the kernel when the connection was
+// made. Unlike the AUTH_SYS credential of a call, it can be trusted.
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
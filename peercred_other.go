@@ -0,0 +1,16 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !linux
+// +build !linux
+
+package sunrpc
+
+import "net"
+
+// unixPeerCred returns the credentials of the peer of conn, which isn't
+// supported on this platform.
+func unixPeerCred(conn *net.UnixConn) (*PeerCred, error) {
+	return nil, ErrPeerCredUnsupported
+}
@@ -0,0 +1,142 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+/*
+From RFC 5531:
+    By convention, procedure 0 of any remote program is defined to be the
+    "null" procedure that takes no arguments and returns no results.  Some
+    RPC client or server implementations use it to verify that a remote
+    program is alive and accepting calls.
+*/
+
+// Ping calls procedure 0 of the program and version specified, which is
+// listening on addr, with void args. It returns nil if the program is alive
+// and supports the version. The network used is determined by the type of
+// addr: *net.TCPAddr, *net.UDPAddr or *net.UnixAddr. Calls over UDP are
+// retransmitted with an increasing interval. If ctx has no deadline, a
+// deadline of 25 seconds is used after which ErrTimeout is returned.
+//
+// Unlike calls made through rpc.Client, the procedure doesn't have to be in
+// the procedure registry.
+func Ping(ctx context.Context, addr net.Addr, programNumber, programVersion uint32) error {
+
+	var network string
+	switch addr.(type) {
+	case *net.TCPAddr:
+		network = "tcp"
+	case *net.UDPAddr:
+		network = "udp"
+	case *net.UnixAddr:
+		network = "unix"
+	default:
+		return ErrProtocolUnsupported
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultUDPTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr.String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unblock reads and writes when ctx is cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	procedureID := ProcedureID{programNumber, programVersion, 0}
+	xid := rand.Uint32()
+
+	if network != "udp" {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+		_, err = callRaw(conn, true, xid, procedureID, nil)
+		return pingError(ctx, err)
+	}
+
+	// Retransmit the call over UDP till a reply arrives
+	interval := defaultUDPRetransmit
+	for {
+		wakeup := time.Now().Add(interval)
+		if deadline.Before(wakeup) {
+			wakeup = deadline
+		}
+		if err := conn.SetDeadline(wakeup); err != nil {
+			return err
+		}
+
+		_, err = callRaw(conn, false, xid, procedureID, nil)
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() || ctx.Err() != nil {
+			return pingError(ctx, err)
+		}
+
+		interval *= 2
+		if interval > maxUDPRetransmit {
+			interval = maxUDPRetransmit
+		}
+	}
+}
+
+// pingError maps errors caused by deadlines expiring to ErrTimeout.
+func pingError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ErrTimeout
+	}
+	return err
+}
+
+// ProbeVersions returns the lowest and highest versions of the program
+// listening on addr. Servers reply to calls made to a version they don't
+// support with the range of versions they do, so procedure 0 of a version
+// outside the range is called. Clients can then pick the highest version
+// supported by both ends. A program which answers calls to any version
+// without reporting a mismatch is returned as supporting versions 0 to
+// math.MaxUint32. See Ping for details of how the call is made.
+func ProbeVersions(ctx context.Context, addr net.Addr, programNumber uint32) (uint32, uint32, error) {
+
+	for _, version := range []uint32{0, math.MaxUint32} {
+		err := Ping(ctx, addr, programNumber, version)
+		var mismatch ErrProgMismatch
+		if errors.As(err, &mismatch) {
+			return mismatch.Low, mismatch.High, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// The program claims to support every version
+	return 0, math.MaxUint32, nil
+}
@@ -0,0 +1,161 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+var pingTestProcs = []Procedure{
+	{ProcedureID{ProgramNumber: 66621, ProgramVersion: 2, ProcedureNumber: 1}, "PingTest.CallV2"},
+	{ProcedureID{ProgramNumber: 66621, ProgramVersion: 3, ProcedureNumber: 1}, "PingTest.CallV3"},
+}
+
+// serveStreamProgram serves server over TCP on the local host and returns
+// the address of the listener.
+func serveStreamProgram(t *testing.T, server *rpc.Server) *net.TCPAddr {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(NewServerCodec(conn, nil))
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr)
+}
+
+// serveNullOnly serves a program over TCP which replies with success to
+// every call, whatever the version, and returns the address of the
+// listener.
+func serveNullOnly(t *testing.T) *net.TCPAddr {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					record, err := ReadFullRecord(conn)
+					if err != nil {
+						return
+					}
+					var call RPCMsg
+					if _, err := xdr.Unmarshal(bytes.NewReader(record), &call); err != nil {
+						return
+					}
+					reply, err := encodeReply(call.Xid, Success, nil)
+					if err != nil {
+						return
+					}
+					if _, err := WriteFullRecord(conn, reply); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr)
+}
+
+func TestPing(t *testing.T) {
+	for _, p := range pingTestProcs {
+		if err := RegisterProcedure(p, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tcpAddr := serveStreamProgram(t, rpc.NewServer())
+	udpAddr := servePacketProgram(t, CallItTest{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		program  uint32
+		version  uint32
+		err      error
+		mismatch *ErrProgMismatch
+	}{
+		{66621, 2, nil, nil},
+		{66621, 3, nil, nil},
+		{66621, 1, nil, &ErrProgMismatch{Low: 2, High: 3}},
+		{66621, 4, nil, &ErrProgMismatch{Low: 2, High: 3}},
+		{66622, 1, ErrProgUnavail, nil},
+	}
+
+	for _, addr := range []net.Addr{tcpAddr, udpAddr} {
+		for _, tc := range tests {
+			err := Ping(ctx, addr, tc.program, tc.version)
+			var mismatch ErrProgMismatch
+			switch {
+			case tc.mismatch != nil:
+				if !errors.As(err, &mismatch) || mismatch != *tc.mismatch {
+					t.Errorf("%s %d/%d: got error %v, want %v", addr.Network(), tc.program, tc.version, err, *tc.mismatch)
+				}
+			case tc.err != nil:
+				if !errors.Is(err, tc.err) {
+					t.Errorf("%s %d/%d: got error %v, want %v", addr.Network(), tc.program, tc.version, err, tc.err)
+				}
+			case err != nil:
+				t.Errorf("%s %d/%d: got error %v", addr.Network(), tc.program, tc.version, err)
+			}
+		}
+	}
+}
+
+func TestProbeVersions(t *testing.T) {
+	for _, p := range pingTestProcs {
+		if err := RegisterProcedure(p, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := serveStreamProgram(t, rpc.NewServer())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if low, high, err := ProbeVersions(ctx, addr, 66621); low != 2 || high != 3 || err != nil {
+		t.Fatalf("got versions %d to %d, %v, want 2 to 3", low, high, err)
+	}
+	if _, _, err := ProbeVersions(ctx, addr, 66622); !errors.Is(err, ErrProgUnavail) {
+		t.Fatalf("got error %v, want %v", err, ErrProgUnavail)
+	}
+
+	// A program that never reports a mismatch claims every version
+	low, high, err := ProbeVersions(ctx, serveNullOnly(t), 66622)
+	if low != 0 || high != math.MaxUint32 || err != nil {
+		t.Fatalf("got versions %d to %d, %v, want 0 to %d", low, high, err, uint32(math.MaxUint32))
+	}
+}
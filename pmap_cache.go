@@ -0,0 +1,261 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Default time for which a port returned by the portmapper is cached
+	defaultPmapCacheTTL = 5 * time.Minute
+	// Default time for which the absence of a program is cached
+	defaultPmapCacheNegativeTTL = 10 * time.Second
+)
+
+type pmapCacheKey struct {
+	host     string
+	program  uint32
+	version  uint32
+	protocol Protocol
+}
+
+type pmapCacheEntry struct {
+	port    uint32 // zero if the program isn't registered
+	expires time.Time
+}
+
+// PmapCache caches the ports returned by portmappers so that clients which
+// repeatedly connect to the same programs don't have to query the
+// portmapper every time. Lookups are cached per (host, program, version,
+// protocol) and made using rpcbind, so programs on hosts reached over IPv6
+// are looked up by the IPv6 netids. Lookups of programs that aren't
+// registered are cached too, for a shorter duration. The zero value of
+// PmapCache is ready to use and a PmapCache can be used concurrently.
+type PmapCache struct {
+	// Client is used to query the portmappers. Its Host field is ignored
+	// and replaced by the host of each lookup.
+	Client PmapClient
+
+	// TTL is the time for which a port is cached. Zero selects the
+	// default of 5 minutes.
+	TTL time.Duration
+
+	// NegativeTTL is the time for which the absence of a program is
+	// cached. Zero selects the default of 10 seconds and a negative value
+	// disables negative caching.
+	NegativeTTL time.Duration
+
+	mutex   sync.Mutex
+	entries map[pmapCacheKey]pmapCacheEntry
+}
+
+func newPmapCacheKey(host string, programNumber, programVersion uint32, protocol Protocol) pmapCacheKey {
+	if host != "" {
+		host = pmapAddress(host)
+	}
+	if protocol == 0 {
+		protocol = IPProtoTCP
+	}
+	return pmapCacheKey{host, programNumber, programVersion, protocol}
+}
+
+// GetPort returns the port number on which the program specified is
+// awaiting call requests on host, querying the portmapper only if there's
+// no cached entry. Zero is returned if the program isn't registered. If
+// host is empty string, localhost is used.
+func (c *PmapCache) GetPort(host string, programNumber, programVersion uint32, protocol Protocol) (uint32, error) {
+	return c.GetPortContext(context.Background(), host, programNumber, programVersion, protocol)
+}
+
+// GetPortContext is like GetPort but the portmapper is queried within the
+// deadline of ctx, if any.
+func (c *PmapCache) GetPortContext(ctx context.Context, host string, programNumber, programVersion uint32, protocol Protocol) (uint32, error) {
+
+	key := newPmapCacheKey(host, programNumber, programVersion, protocol)
+
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.port, nil
+	}
+
+	client, err := lookupClient(ctx, c.Client, host)
+	if err != nil {
+		return 0, err
+	}
+	netid, err := programNetid(host, key.protocol)
+	if err != nil {
+		return 0, err
+	}
+	addr, err := client.RpcbGetNetAddr(programNumber, programVersion, netid)
+	if err != nil {
+		return 0, err
+	}
+	port := uint32(addrPort(addr))
+
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = defaultPmapCacheTTL
+	}
+	if port == 0 {
+		ttl = c.NegativeTTL
+		if ttl == 0 {
+			ttl = defaultPmapCacheNegativeTTL
+		}
+	}
+
+	if ttl > 0 {
+		now := time.Now()
+		c.mutex.Lock()
+		if c.entries == nil {
+			c.entries = make(map[pmapCacheKey]pmapCacheEntry)
+		}
+		// Entries are only looked up by key, so the expired ones are
+		// swept here to keep the cache from growing without bound.
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.entries[key] = pmapCacheEntry{port, now.Add(ttl)}
+		c.mutex.Unlock()
+	}
+
+	return port, nil
+}
+
+// Invalidate removes the cached entry of the program specified, if any.
+func (c *PmapCache) Invalidate(host string, programNumber, programVersion uint32, protocol Protocol) {
+
+	key := newPmapCacheKey(host, programNumber, programVersion, protocol)
+
+	c.mutex.Lock()
+	delete(c.entries, key)
+	c.mutex.Unlock()
+}
+
+// Flush removes all cached entries.
+func (c *PmapCache) Flush() {
+	c.mutex.Lock()
+	c.entries = nil
+	c.mutex.Unlock()
+}
+
+// programHost returns the host part of the address of a portmapper, which
+// is where the programs it maps are to be reached.
+func programHost(host string) string {
+	if host == "" {
+		return "localhost"
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// lookupClient returns a copy of client which queries the portmapper on host
+// within the deadline of ctx, if any.
+func lookupClient(ctx context.Context, client PmapClient, host string) (PmapClient, error) {
+
+	if err := ctx.Err(); err != nil {
+		return client, err
+	}
+
+	client.Host = host
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return client, context.DeadlineExceeded
+		}
+		if client.Timeout <= 0 || timeout < client.Timeout {
+			client.Timeout = timeout
+		}
+	}
+
+	return client, nil
+}
+
+// programNetid returns the netid by which programs on host are looked up
+// for the protocol specified. Programs on hosts reached over IPv6 are looked
+// up by the IPv6 netid.
+func programNetid(host string, protocol Protocol) (string, error) {
+
+	network, err := protocolNetwork(protocol)
+	if err != nil {
+		return "", err
+	}
+	netid := NetidTCP
+	if network == "udp" {
+		netid = NetidUDP
+	}
+
+	hostname := programHost(host)
+	if ip := net.ParseIP(strings.SplitN(hostname, "%", 2)[0]); ip != nil && ip.To4() == nil {
+		netid += "6"
+	}
+
+	return netid, nil
+}
+
+// protocolNetwork returns the name of the network, as used by package net,
+// for the protocol specified. Zero value of Protocol means TCP.
+func protocolNetwork(protocol Protocol) (string, error) {
+	switch protocol {
+	case 0, IPProtoTCP:
+		return "tcp", nil
+	case IPProtoUDP:
+		return "udp", nil
+	}
+	return "", ErrProtocolUnsupported
+}
+
+// DialContext looks up the port of the program specified with the
+// portmapper on host and connects to it over the protocol specified. If
+// connecting to a cached port fails, the entry is invalidated, as the
+// program has probably been restarted on a different port, and the lookup
+// and dial are retried once. ErrProgNotRegistered is returned if the
+// program isn't registered.
+//
+// Dialing over UDP succeeds even if the program isn't listening on the
+// port, so callers should Invalidate the entry if the first call made over
+// the connection fails with ErrTimeout or ECONNREFUSED. DialProgram does so.
+func (c *PmapCache) DialContext(ctx context.Context, host string, programNumber, programVersion uint32, protocol Protocol) (net.Conn, error) {
+
+	network, err := protocolNetwork(protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	retried := false
+	for {
+		port, err := c.GetPortContext(ctx, host, programNumber, programVersion, protocol)
+		if err != nil {
+			return nil, err
+		}
+		if port == 0 {
+			return nil, ErrProgNotRegistered
+		}
+
+		address := net.JoinHostPort(programHost(host), strconv.Itoa(int(port)))
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+
+		c.Invalidate(host, programNumber, programVersion, protocol)
+		if retried || ctx.Err() != nil {
+			return nil, err
+		}
+		retried = true
+	}
+}
@@ -0,0 +1,168 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// silentPmap returns the address of a UDP socket that never replies.
+func silentPmap(t *testing.T) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestPmapCacheLookupDeadline(t *testing.T) {
+	cache := &PmapCache{Client: PmapClient{Protocol: IPProtoUDP}}
+	host := silentPmap(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := cache.GetPortContext(ctx, host, 66603, 1, IPProtoTCP)
+	if err != ErrTimeout {
+		t.Fatalf("got error %v, want %v", err, ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("lookup took %v past the deadline of ctx", elapsed)
+	}
+
+	if _, err := cache.DialContext(ctx, host, 66603, 1, IPProtoTCP); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+var cacheTestProc = ProcedureID{ProgramNumber: 66603, ProgramVersion: 1, ProcedureNumber: 1}
+
+// servePmap serves a PmapServer over TCP on address and returns it along
+// with the address of its listener.
+func servePmap(t *testing.T, address string) (*PmapServer, string) {
+	t.Helper()
+
+	s, err := NewPmapServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skip(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { l.Close() })
+
+	return s, l.Addr().String()
+}
+
+// cached returns the number of entries in cache.
+func cached(cache *PmapCache) int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return len(cache.entries)
+}
+
+func TestPmapCacheIPv6(t *testing.T) {
+	s, host := servePmap(t, "[::1]:0")
+	setMapping(t, s, RPCB{Program: 66603, Version: 1, Netid: NetidTCP6, Addr: "::1.8.1"})
+	setMapping(t, s, RPCB{Program: 66603, Version: 1, Netid: NetidUDP6, Addr: "::1.8.2"})
+
+	var cache PmapCache
+	for protocol, want := range map[Protocol]uint32{IPProtoTCP: 2049, IPProtoUDP: 2050} {
+		for i := 0; i < 2; i++ {
+			port, err := cache.GetPort(host, 66603, 1, protocol)
+			if err != nil || port != want {
+				t.Fatalf("protocol %d: got port %d, %v, want %d", protocol, port, err, want)
+			}
+		}
+	}
+	if n := cached(&cache); n != 2 {
+		t.Fatalf("got %d entries cached, want 2", n)
+	}
+}
+
+func TestPmapCacheSweep(t *testing.T) {
+	_, host := servePmap(t, "127.0.0.1:0")
+	cache := &PmapCache{NegativeTTL: 50 * time.Millisecond}
+
+	for program := uint32(66610); program < 66620; program++ {
+		if _, err := cache.GetPort(host, program, 1, IPProtoTCP); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cached(cache); n != 10 {
+		t.Fatalf("got %d entries cached, want 10", n)
+	}
+
+	// Expired entries are swept on insert
+	time.Sleep(100 * time.Millisecond)
+	if _, err := cache.GetPort(host, 66620, 1, IPProtoTCP); err != nil {
+		t.Fatal(err)
+	}
+	if n := cached(cache); n != 1 {
+		t.Fatalf("got %d entries cached after sweep, want 1", n)
+	}
+}
+
+func TestPmapCacheUDPUnreachable(t *testing.T) {
+	if err := RegisterProcedure(Procedure{cacheTestProc, "CacheTest.Call"}, true); err != nil {
+		t.Fatal(err)
+	}
+	s, host := servePmap(t, "127.0.0.1:0")
+
+	// Nothing replies on the port of the first program and nothing
+	// listens on the port of the second.
+	silent, err := net.ResolveUDPAddr("udp", silentPmap(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	for _, addr := range []net.Addr{silent, closed.LocalAddr()} {
+		_, uaddr, err := FormatUniversalAddress(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Unset(66603, 1, NetidUDP); err != nil {
+			t.Fatal(err)
+		}
+		setMapping(t, s, RPCB{Program: 66603, Version: 1, Netid: NetidUDP, Addr: uaddr})
+
+		cache := new(PmapCache)
+		rpcClient, err := DialProgram(context.Background(), host, 66603, 1, &DialOptions{
+			Protocols:  []Protocol{IPProtoUDP},
+			Cache:      cache,
+			Timeout:    200 * time.Millisecond,
+			Retransmit: 50 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := cached(cache); n != 1 {
+			t.Fatalf("%s: got %d entries cached, want 1", addr, n)
+		}
+
+		if err := WrapClient(rpcClient).Call("CacheTest.Call", nil, nil); err == nil {
+			t.Fatalf("%s: call succeeded", addr)
+		}
+		rpcClient.Close()
+		if n := cached(cache); n != 0 {
+			t.Fatalf("%s: entry not invalidated after failed call", addr)
+		}
+	}
+}
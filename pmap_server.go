@@ -0,0 +1,873 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+// Owners of mappings as known to the server. Callers other than the
+// superuser may only unregister mappings they own.
+const (
+	unknownOwner   = "unknown" // caller whose identity isn't verified
+	superuserOwner = "0"
+)
+
+const (
+	// Time to wait for the remote program to reply to a forwarded call
+	pmapForwardTimeout = 3 * time.Second
+
+	// Max number of datagrams served at once by ServePacket
+	pmapPacketWorkers = 16
+
+	// Transport semantics as returned in RPCBEntry
+	ncTpiClts    = 1 // connectionless
+	ncTpiCotsOrd = 3 // connection oriented with orderly release
+)
+
+// PmapServer is an in-memory implementation of the portmapper (version 2)
+// and rpcbind (versions 3 and 4) programs. It can be used in place of the
+// system's rpcbind by tests and in environments where rpcbind isn't
+// available. Only callers connecting from the local host are allowed to
+// register and unregister programs. Programs registered over a Unix domain
+// socket are owned by the user of the caller, if the platform can tell, and
+// others by "unknown". Callers may only unregister programs they own, unless
+// they're the superuser.
+type PmapServer struct {
+	mutex    sync.RWMutex // protects mappings and stats
+	mappings []RPCB
+	stats    [rpcbVersStat]RPCBStat
+	path     string // file to which mappings are persisted, if any
+}
+
+// NewPmapServer returns a new PmapServer. If path is not empty, the mappings
+// are persisted to the file at path whenever they change, and mappings
+// present in the file are loaded.
+func NewPmapServer(path string) (*PmapServer, error) {
+
+	registryInit.Do(initRegistry)
+
+	s := &PmapServer{path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	var saved RPCBDumpReply
+	if _, err := xdr.Unmarshal(bytes.NewReader(data), &saved); err != nil {
+		return nil, err
+	}
+	for trav := saved.Next; trav != nil; trav = trav.Next {
+		s.mappings = append(s.mappings, trav.Map)
+	}
+
+	return s, nil
+}
+
+// Serve accepts incoming connections on the listener and serves portmapper
+// and rpcbind requests on each of them. The listener can be bound to any
+// address, not necessarily port 111. Serve returns when Accept() fails.
+func (s *PmapServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves portmapper and rpcbind requests on a single connection.
+// It blocks until the client hangs up.
+func (s *PmapServer) ServeConn(conn net.Conn) {
+
+	owner := ""
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		if cred, err := unixPeerCred(unixConn); err == nil {
+			owner = strconv.FormatUint(uint64(cred.UID), 10)
+		}
+	}
+
+	server := s.newRPCServer(conn.LocalAddr(), isLocalAddr(conn.RemoteAddr()), owner)
+	server.ServeCodec(NewServerCodec(conn, nil))
+}
+
+// ServePacket serves portmapper and rpcbind requests arriving on the
+// datagram conn. It returns when reading from conn fails.
+func (s *PmapServer) ServePacket(conn net.PacketConn) error {
+
+	// Callers are trusted based on their address
+	servers := map[bool]*rpc.Server{
+		true:  s.newRPCServer(conn.LocalAddr(), true, ""),
+		false: s.newRPCServer(conn.LocalAddr(), false, ""),
+	}
+
+	// Forwarded calls may take a while, so a bounded number of datagrams
+	// are served concurrently. Datagrams arriving while all workers are
+	// busy wait in the socket's buffer.
+	workers := make(chan struct{}, pmapPacketWorkers)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		workers <- struct{}{}
+		server := servers[isLocalAddr(addr)]
+		codec := newDatagramServerCodec(conn, addr, append([]byte(nil), buf[:n]...))
+		go func() {
+			defer func() { <-workers }()
+			server.ServeRequest(codec)
+		}()
+	}
+}
+
+func (s *PmapServer) newRPCServer(localAddr net.Addr, local bool, owner string) *rpc.Server {
+	if owner == "" {
+		owner = unknownOwner
+	}
+	server := rpc.NewServer()
+	_ = server.RegisterName("Pmap", &pmapHandler{s, localAddr, local, owner})
+	_ = server.RegisterName("RpcbV3", &rpcbHandler{s, localAddr, local, owner, rpcbindVersion3})
+	_ = server.RegisterName("RpcbV4", &rpcbHandler{s, localAddr, local, owner, rpcbindVersion4})
+	return server
+}
+
+// Mappings returns a copy of all mappings currently registered.
+func (s *PmapServer) Mappings() []RPCB {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append([]RPCB(nil), s.mappings...)
+}
+
+// Set registers the mapping specified. It returns false if a mapping for
+// the same program, version and netid already exists. If the mappings
+// can't be persisted, the mapping isn't registered and the error is
+// returned.
+func (s *PmapServer) Set(binding RPCB) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if binding.Netid == "" || s.find(binding.Program, binding.Version, binding.Netid) >= 0 {
+		return false, nil
+	}
+	s.mappings = append(s.mappings, binding)
+	if err := s.persist(); err != nil {
+		s.mappings = s.mappings[:len(s.mappings)-1]
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Unset unregisters the mapping of the program and version specified. If
+// netid is empty string, mappings on all transports are unregistered. It
+// returns false if no mapping was found. If the mappings can't be
+// persisted, nothing is unregistered and the error is returned.
+func (s *PmapServer) Unset(programNumber, programVersion uint32, netid string) (bool, error) {
+	return s.unset(programNumber, programVersion, netid, superuserOwner)
+}
+
+// unset unregisters the mappings specified on behalf of owner. Nothing is
+// unregistered if any of the mappings is owned by someone else, unless
+// owner is the superuser.
+func (s *PmapServer) unset(programNumber, programVersion uint32, netid, owner string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	matches := func(m RPCB) bool {
+		return m.Program == programNumber && m.Version == programVersion &&
+			(netid == "" || m.Netid == netid)
+	}
+
+	if owner != superuserOwner {
+		for _, m := range s.mappings {
+			if matches(m) && m.Owner != owner {
+				return false, nil
+			}
+		}
+	}
+
+	var found bool
+	mappings := make([]RPCB, 0, len(s.mappings))
+	for _, m := range s.mappings {
+		if matches(m) {
+			found = true
+			continue
+		}
+		mappings = append(mappings, m)
+	}
+	if !found {
+		return false, nil
+	}
+
+	old := s.mappings
+	s.mappings = mappings
+	if err := s.persist(); err != nil {
+		s.mappings = old
+		return false, err
+	}
+
+	return true, nil
+}
+
+// find returns the index of the mapping specified or -1 if there's none.
+// The caller must hold the mutex.
+func (s *PmapServer) find(programNumber, programVersion uint32, netid string) int {
+	for i, m := range s.mappings {
+		if m.Program == programNumber && m.Version == programVersion && m.Netid == netid {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookup returns the mapping of the program on the transport specified. If
+// exactVersion is false and the version specified isn't registered, mapping
+// of any other version of the program is returned so that the caller gets a
+// ProgMismatch from the program itself.
+func (s *PmapServer) lookup(programNumber, programVersion uint32, netid string, exactVersion bool) (RPCB, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if i := s.find(programNumber, programVersion, netid); i >= 0 {
+		return s.mappings[i], true
+	}
+
+	if !exactVersion {
+		for _, m := range s.mappings {
+			if m.Program == programNumber && m.Netid == netid {
+				return m, true
+			}
+		}
+	}
+
+	return RPCB{}, false
+}
+
+// persist writes all mappings to the file at s.path. The caller must hold
+// the mutex.
+func (s *PmapServer) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var saved RPCBDumpReply
+	for i := len(s.mappings) - 1; i >= 0; i-- {
+		saved.Next = &rpcbList{Map: s.mappings[i], Next: saved.Next}
+	}
+
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, &saved); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// countCall updates the statistics of procedure calls for the version of
+// the protocol specified.
+func (s *PmapServer) countCall(version uint32, procedure uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stat := &s.stats[version-portmapperProgramVersion]
+	if procedure < rpcbStatHighProc {
+		stat.Info[procedure]++
+	}
+}
+
+// countLookup updates the statistics of GETPORT and GETADDR calls.
+func (s *PmapServer) countLookup(version uint32, programNumber, programVersion uint32, netid string, success bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stat := &s.stats[version-portmapperProgramVersion]
+
+	var entry *RPCBStatAddr
+	for i := range stat.AddrInfo {
+		e := &stat.AddrInfo[i]
+		if e.Program == programNumber && e.Version == programVersion && e.Netid == netid {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		stat.AddrInfo = append(stat.AddrInfo, RPCBStatAddr{
+			Program: programNumber,
+			Version: programVersion,
+			Netid:   netid,
+		})
+		entry = &stat.AddrInfo[len(stat.AddrInfo)-1]
+	}
+
+	if success {
+		entry.Success++
+	} else {
+		entry.Failure++
+	}
+}
+
+// countSet updates the statistics of SET and UNSET calls.
+func (s *PmapServer) countSet(version uint32, set bool, success bool) {
+	if !success {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stat := &s.stats[version-portmapperProgramVersion]
+	if set {
+		stat.SetInfo++
+	} else {
+		stat.UnsetInfo++
+	}
+}
+
+// countRmtCall updates the statistics of indirect calls.
+func (s *PmapServer) countRmtCall(version uint32, args *PmapCallArgs, netid string, indirect bool, success bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stat := &s.stats[version-portmapperProgramVersion]
+
+	var entry *RPCBStatRmtCall
+	for i := range stat.RmtInfo {
+		e := &stat.RmtInfo[i]
+		if e.Program == args.Program && e.Version == args.Version &&
+			e.Procedure == args.Procedure && e.Netid == netid {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		stat.RmtInfo = append(stat.RmtInfo, RPCBStatRmtCall{
+			Program:   args.Program,
+			Version:   args.Version,
+			Procedure: args.Procedure,
+			Netid:     netid,
+		})
+		entry = &stat.RmtInfo[len(stat.RmtInfo)-1]
+	}
+
+	if indirect {
+		entry.Indirect++
+	}
+	if success {
+		entry.Success++
+	} else {
+		entry.Failure++
+	}
+}
+
+// forward makes the call specified by args to the program on the local
+// host and returns the address of the program and the result of the call.
+func (s *PmapServer) forward(args *PmapCallArgs) (RPCB, []byte, error) {
+
+	binding, ok := s.lookup(args.Program, args.Version, NetidUDP, true)
+	if !ok {
+		if binding, ok = s.lookup(args.Program, args.Version, NetidTCP, true); !ok {
+			return binding, nil, ErrProgUnavail
+		}
+	}
+
+	addr, err := ParseUniversalAddress(binding.Netid, binding.Addr)
+	if err != nil {
+		return binding, nil, err
+	}
+
+	network, _ := NetidNetwork(binding.Netid)
+	conn, err := net.DialTimeout(network, net.JoinHostPort("127.0.0.1", strconv.Itoa(addrPort(addr))), pmapForwardTimeout)
+	if err != nil {
+		return binding, nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(pmapForwardTimeout)); err != nil {
+		return binding, nil, err
+	}
+
+	procedureID := ProcedureID{args.Program, args.Version, args.Procedure}
+	result, err := callRaw(conn, binding.Netid == NetidTCP, rand.Uint32(), procedureID, args.Args)
+	return binding, result, err
+}
+
+// callRaw makes a single call to the remote procedure specified using
+// already marshalled args and returns the unmarshalled procedure-specific
+// result. If stream is true, record marking is used.
+func callRaw(conn net.Conn, stream bool, xid uint32, procedureID ProcedureID, args []byte) ([]byte, error) {
+
+	payload, err := encodeCall(xid, procedureID, nil)
+	if err != nil {
+		return nil, err
+	}
+	payload = append(payload, args...)
+
+	if stream {
+		_, err = WriteFullRecord(conn, payload)
+	} else {
+		_, err = conn.Write(payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var record []byte
+		if stream {
+			record, err = ReadFullRecord(conn)
+		} else {
+			record = make([]byte, maxDatagramSize)
+			var n int
+			n, err = conn.Read(record)
+			record = record[:n]
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		reader := bytes.NewReader(record)
+		var reply RPCMsg
+		if _, err := xdr.Unmarshal(reader, &reply); err != nil {
+			return nil, err
+		}
+		if reply.Xid != xid {
+			// Stale reply to an earlier call
+			continue
+		}
+		if err := replyError(&reply, procedureID, conn.RemoteAddr()); err != nil {
+			return nil, err
+		}
+
+		result := make([]byte, reader.Len())
+		_, _ = reader.Read(result)
+		return result, nil
+	}
+}
+
+// isLocalAddr returns true if the remote address belongs to the local host.
+func isLocalAddr(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.IsLoopback()
+	case *net.UDPAddr:
+		return a.IP.IsLoopback()
+	case *net.UnixAddr:
+		return true
+	}
+	return false
+}
+
+// addrNetid returns the netid of the transport of the address.
+func addrNetid(addr net.Addr) string {
+	netid, _, err := FormatUniversalAddress(addr)
+	if err != nil {
+		return ""
+	}
+	return netid
+}
+
+// mergeAddr replaces the wildcard address in the universal address of a
+// mapping by the local address on which the caller reached us, as that's
+// the address the caller can reach the program at.
+func mergeAddr(binding RPCB, localAddr net.Addr) string {
+
+	addr, err := ParseUniversalAddress(binding.Netid, binding.Addr)
+	if err != nil {
+		return binding.Addr
+	}
+
+	var ip net.IP
+	switch a := localAddr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return binding.Addr
+	}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if !a.IP.IsUnspecified() || (a.IP.To4() == nil) != (ip.To4() == nil) {
+			return binding.Addr
+		}
+		a.IP = ip
+	case *net.UDPAddr:
+		if !a.IP.IsUnspecified() || (a.IP.To4() == nil) != (ip.To4() == nil) {
+			return binding.Addr
+		}
+		a.IP = ip
+	}
+
+	_, uaddr, err := FormatUniversalAddress(addr)
+	if err != nil {
+		return binding.Addr
+	}
+	return uaddr
+}
+
+// pmapHandler implements the procedures of portmapper version 2.
+type pmapHandler struct {
+	server    *PmapServer
+	localAddr net.Addr // address on which the caller reached us
+	local     bool     // caller is on the local host
+	owner     string   // uid of the caller verified by the kernel or unknownOwner
+}
+
+// ProcNull does nothing.
+func (h *pmapHandler) ProcNull(args struct{}, reply *struct{}) error {
+	h.server.countCall(portmapperProgramVersion, 0)
+	return nil
+}
+
+// ProcSet registers the mapping specified.
+func (h *pmapHandler) ProcSet(args *PortMapping, reply *bool) error {
+	h.server.countCall(portmapperProgramVersion, 1)
+
+	netid := ProtocolToNetid(Protocol(args.Protocol))
+	if !h.local || netid == "" {
+		*reply = false
+		return nil
+	}
+
+	_, uaddr, err := FormatUniversalAddress(protocolAddr(Protocol(args.Protocol), net.IPv4zero, int(args.Port)))
+	if err != nil {
+		*reply = false
+		return nil
+	}
+
+	*reply, err = h.server.Set(RPCB{
+		Program: args.Program,
+		Version: args.Version,
+		Netid:   netid,
+		Addr:    uaddr,
+		Owner:   h.owner,
+	})
+	h.server.countSet(portmapperProgramVersion, true, *reply)
+	return err
+}
+
+// ProcUnset unregisters the program and version specified on both TCP and
+// UDP, if owned by the caller.
+func (h *pmapHandler) ProcUnset(args *PortMapping, reply *bool) error {
+	h.server.countCall(portmapperProgramVersion, 2)
+
+	if !h.local {
+		*reply = false
+		return nil
+	}
+
+	tcp, err := h.server.unset(args.Program, args.Version, NetidTCP, h.owner)
+	udp := false
+	if err == nil {
+		udp, err = h.server.unset(args.Program, args.Version, NetidUDP, h.owner)
+	}
+	*reply = tcp || udp
+	h.server.countSet(portmapperProgramVersion, false, *reply)
+	return err
+}
+
+// ProcGetPort returns the port of the mapping specified or 0 if the program
+// isn't registered.
+func (h *pmapHandler) ProcGetPort(args *PortMapping, reply *uint32) error {
+	h.server.countCall(portmapperProgramVersion, 3)
+
+	*reply = 0
+	netid := ProtocolToNetid(Protocol(args.Protocol))
+	binding, ok := h.server.lookup(args.Program, args.Version, netid, false)
+	if ok {
+		if addr, err := ParseUniversalAddress(binding.Netid, binding.Addr); err == nil {
+			*reply = uint32(addrPort(addr))
+		}
+	}
+
+	h.server.countLookup(portmapperProgramVersion, args.Program, args.Version, netid, *reply != 0)
+	return nil
+}
+
+// ProcDump returns all TCP and UDP mappings.
+func (h *pmapHandler) ProcDump(args struct{}, reply *PmapDumpReply) error {
+	h.server.countCall(portmapperProgramVersion, 4)
+
+	mappings := h.server.Mappings()
+	for i := len(mappings) - 1; i >= 0; i-- {
+		m := mappings[i]
+		protocol, err := NetidToProtocol(m.Netid)
+		if err != nil {
+			continue
+		}
+		addr, err := ParseUniversalAddress(m.Netid, m.Addr)
+		if err != nil {
+			continue
+		}
+		reply.Next = &portMappingList{
+			Map: PortMapping{
+				Program:  m.Program,
+				Version:  m.Version,
+				Protocol: uint32(protocol),
+				Port:     uint32(addrPort(addr)),
+			},
+			Next: reply.Next,
+		}
+	}
+
+	return nil
+}
+
+// ProcCallIt calls the procedure specified on the local host.
+func (h *pmapHandler) ProcCallIt(args *PmapCallArgs, reply *PmapCallResult) error {
+	h.server.countCall(portmapperProgramVersion, 5)
+
+	binding, result, err := h.server.forward(args)
+	h.server.countRmtCall(portmapperProgramVersion, args, addrNetid(h.localAddr), false, err == nil)
+	if err != nil {
+		return errNoReply
+	}
+
+	addr, err := ParseUniversalAddress(binding.Netid, binding.Addr)
+	if err != nil {
+		return err
+	}
+
+	reply.Port = uint32(addrPort(addr))
+	reply.Result = result
+	return nil
+}
+
+// rpcbHandler implements the procedures of rpcbind versions 3 and 4.
+type rpcbHandler struct {
+	server    *PmapServer
+	localAddr net.Addr // address on which the caller reached us
+	local     bool     // caller is on the local host
+	owner     string   // uid of the caller verified by the kernel or unknownOwner
+	version   uint32
+}
+
+// ProcNull does nothing.
+func (h *rpcbHandler) ProcNull(args struct{}, reply *struct{}) error {
+	h.server.countCall(h.version, 0)
+	return nil
+}
+
+// ProcSet registers the mapping specified.
+func (h *rpcbHandler) ProcSet(args *RPCB, reply *bool) error {
+	h.server.countCall(h.version, 1)
+
+	*reply = false
+	var err error
+	if h.local {
+		// The owner asserted by the caller isn't trusted
+		args.Owner = h.owner
+		if _, perr := ParseUniversalAddress(args.Netid, args.Addr); perr == nil {
+			*reply, err = h.server.Set(*args)
+		}
+	}
+
+	h.server.countSet(h.version, true, *reply)
+	return err
+}
+
+// ProcUnset unregisters the mapping specified, if owned by the caller.
+func (h *rpcbHandler) ProcUnset(args *RPCB, reply *bool) error {
+	h.server.countCall(h.version, 2)
+
+	*reply = false
+	var err error
+	if h.local {
+		*reply, err = h.server.unset(args.Program, args.Version, args.Netid, h.owner)
+	}
+	h.server.countSet(h.version, false, *reply)
+	return err
+}
+
+func (h *rpcbHandler) getAddr(args *RPCB, exactVersion bool) string {
+	netid := args.Netid
+	if netid == "" {
+		netid = addrNetid(h.localAddr)
+	}
+
+	var uaddr string
+	binding, ok := h.server.lookup(args.Program, args.Version, netid, exactVersion)
+	if ok {
+		uaddr = mergeAddr(binding, h.localAddr)
+	}
+
+	h.server.countLookup(h.version, args.Program, args.Version, netid, ok)
+	return uaddr
+}
+
+// ProcGetAddr returns the universal address of the mapping specified or
+// an empty string if the program isn't registered.
+func (h *rpcbHandler) ProcGetAddr(args *RPCB, reply *string) error {
+	h.server.countCall(h.version, 3)
+
+	*reply = h.getAddr(args, false)
+	return nil
+}
+
+// ProcDump returns all mappings.
+func (h *rpcbHandler) ProcDump(args struct{}, reply *RPCBDumpReply) error {
+	h.server.countCall(h.version, 4)
+
+	mappings := h.server.Mappings()
+	for i := len(mappings) - 1; i >= 0; i-- {
+		reply.Next = &rpcbList{Map: mappings[i], Next: reply.Next}
+	}
+
+	return nil
+}
+
+func (h *rpcbHandler) rmtCall(args *PmapCallArgs, reply *RPCBRmtCallResult, indirect bool) error {
+	binding, result, err := h.server.forward(args)
+	h.server.countRmtCall(h.version, args, addrNetid(h.localAddr), indirect, err == nil)
+	if err != nil {
+		return errNoReply
+	}
+
+	reply.Addr = mergeAddr(binding, h.localAddr)
+	reply.Result = result
+	return nil
+}
+
+// ProcCallIt calls the procedure specified on the local host.
+func (h *rpcbHandler) ProcCallIt(args *PmapCallArgs, reply *RPCBRmtCallResult) error {
+	h.server.countCall(h.version, 5)
+	return h.rmtCall(args, reply, false)
+}
+
+// ProcBcast calls the procedure specified on the local host.
+func (h *rpcbHandler) ProcBcast(args *PmapCallArgs, reply *RPCBRmtCallResult) error {
+	h.server.countCall(h.version, 5)
+	return h.rmtCall(args, reply, false)
+}
+
+// ProcGetTime returns the local time in seconds since the epoch.
+func (h *rpcbHandler) ProcGetTime(args struct{}, reply *uint32) error {
+	h.server.countCall(h.version, 6)
+
+	*reply = uint32(time.Now().Unix())
+	return nil
+}
+
+// ProcGetVersAddr returns the universal address of the mapping specified
+// only if the exact version of the program is registered.
+func (h *rpcbHandler) ProcGetVersAddr(args *RPCB, reply *string) error {
+	h.server.countCall(h.version, 9)
+
+	*reply = h.getAddr(args, true)
+	return nil
+}
+
+// ProcIndirect calls the procedure specified on the local host.
+func (h *rpcbHandler) ProcIndirect(args *PmapCallArgs, reply *RPCBRmtCallResult) error {
+	h.server.countCall(h.version, 10)
+	return h.rmtCall(args, reply, true)
+}
+
+// ProcGetAddrList returns the addresses of the program on all transports
+// of the same protocol family as the netid specified.
+func (h *rpcbHandler) ProcGetAddrList(args *RPCB, reply *RPCBEntryListReply) error {
+	h.server.countCall(h.version, 11)
+
+	netid := args.Netid
+	if netid == "" {
+		netid = addrNetid(h.localAddr)
+	}
+	family := netidProtoFamily(netid)
+
+	mappings := h.server.Mappings()
+	for i := len(mappings) - 1; i >= 0; i-- {
+		m := mappings[i]
+		if m.Program != args.Program || m.Version != args.Version ||
+			netidProtoFamily(m.Netid) != family {
+			continue
+		}
+
+		entry := RPCBEntry{
+			MAddr:       mergeAddr(m, h.localAddr),
+			Netid:       m.Netid,
+			Semantics:   ncTpiCotsOrd,
+			ProtoFamily: family,
+			Proto:       "tcp",
+		}
+		switch m.Netid {
+		case NetidUDP, NetidUDP6:
+			entry.Semantics = ncTpiClts
+			entry.Proto = "udp"
+		case NetidLocal:
+			entry.Proto = "-"
+		}
+
+		reply.Next = &rpcbEntryList{Entry: entry, Next: reply.Next}
+	}
+
+	return nil
+}
+
+// ProcGetStat returns the statistics of all versions of the protocol.
+func (h *rpcbHandler) ProcGetStat(args struct{}, reply *[rpcbVersStat]rpcbStat) error {
+	h.server.countCall(h.version, 12)
+
+	h.server.mutex.RLock()
+	defer h.server.mutex.RUnlock()
+
+	for i, stat := range h.server.stats {
+		reply[i].Info = stat.Info
+		reply[i].SetInfo = stat.SetInfo
+		reply[i].UnsetInfo = stat.UnsetInfo
+		for j := len(stat.AddrInfo) - 1; j >= 0; j-- {
+			reply[i].AddrInfo = &rpcbStatAddrList{Stat: stat.AddrInfo[j], Next: reply[i].AddrInfo}
+		}
+		for j := len(stat.RmtInfo) - 1; j >= 0; j-- {
+			reply[i].RmtInfo = &rpcbStatRmtCallList{Stat: stat.RmtInfo[j], Next: reply[i].RmtInfo}
+		}
+	}
+
+	return nil
+}
+
+// netidProtoFamily returns the protocol family of the netid specified.
+func netidProtoFamily(netid string) string {
+	switch netid {
+	case NetidTCP, NetidUDP:
+		return "inet"
+	case NetidTCP6, NetidUDP6:
+		return "inet6"
+	case NetidLocal:
+		return "loopback"
+	}
+	return ""
+}
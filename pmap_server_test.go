@@ -0,0 +1,221 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// setMapping registers binding with s.
+func setMapping(t *testing.T, s *PmapServer, binding RPCB) {
+	t.Helper()
+
+	if ok, err := s.Set(binding); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+// mappingOwner returns the owner of the mapping specified and false if
+// there's none.
+func mappingOwner(s *PmapServer, programNumber, programVersion uint32, netid string) (string, bool) {
+	for _, m := range s.Mappings() {
+		if m.Program == programNumber && m.Version == programVersion && m.Netid == netid {
+			return m.Owner, true
+		}
+	}
+	return "", false
+}
+
+func TestPmapServerOwnerUnknown(t *testing.T) {
+	s, host := servePmap(t, "127.0.0.1:0")
+	client := &PmapClient{Host: host}
+
+	// The owner asserted by a caller over TCP isn't trusted
+	if ok, err := client.RpcbSet(66604, 1, NetidTCP, "127.0.0.1.8.1"); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if ok, err := client.Set(66604, 2, IPProtoUDP, 2049); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	for _, m := range s.Mappings() {
+		if m.Owner != unknownOwner {
+			t.Fatalf("mapping %+v not owned by %q", m, unknownOwner)
+		}
+	}
+
+	// which can unset its own mappings
+	if ok, err := client.RpcbUnset(66604, 1, NetidTCP); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if ok, err := client.Unset(66604, 2); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if len(s.Mappings()) != 0 {
+		t.Fatalf("mappings left: %+v", s.Mappings())
+	}
+}
+
+func TestPmapServerUnsetOwned(t *testing.T) {
+	s, host := servePmap(t, "127.0.0.1:0")
+	client := &PmapClient{Host: host}
+
+	setMapping(t, s, RPCB{Program: 66605, Version: 1, Netid: NetidTCP, Addr: "0.0.0.0.8.1", Owner: "1234"})
+	setMapping(t, s, RPCB{Program: 66605, Version: 1, Netid: NetidUDP, Addr: "0.0.0.0.8.1", Owner: "1234"})
+
+	if ok, err := client.RpcbUnset(66605, 1, NetidTCP); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if ok, err := client.RpcbUnset(66605, 1, ""); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if ok, err := client.Unset(66605, 1); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if len(s.Mappings()) != 2 {
+		t.Fatalf("mappings of another owner removed: %+v", s.Mappings())
+	}
+
+	// The superuser unsets any mapping
+	if ok, err := s.unset(66605, 1, NetidTCP, superuserOwner); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if ok, err := s.Unset(66605, 1, NetidUDP); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if len(s.Mappings()) != 0 {
+		t.Fatalf("mappings left: %+v", s.Mappings())
+	}
+}
+
+func TestPmapServerOwnerPeerCred(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpcbind.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	s, err := NewPmapServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+
+	client := &PmapClient{Socket: path}
+	if ok, err := client.RpcbSet(66606, 1, NetidTCP, "0.0.0.0.8.1"); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+
+	uid, ok := mappingOwner(s, 66606, 1, NetidTCP)
+	if !ok {
+		t.Fatal("mapping not registered")
+	}
+	if uid == unknownOwner {
+		t.Skip("peer credentials unsupported")
+	}
+	if uid != strconv.Itoa(os.Getuid()) {
+		t.Fatalf("mapping owned by %q, want uid %d", uid, os.Getuid())
+	}
+
+	// Mappings of unverified callers are left alone, unless the caller
+	// is the superuser
+	setMapping(t, s, RPCB{Program: 66606, Version: 2, Netid: NetidTCP, Addr: "0.0.0.0.8.1", Owner: unknownOwner})
+	ok, err = client.RpcbUnset(66606, 2, NetidTCP)
+	if err != nil || ok != (uid == superuserOwner) {
+		t.Fatal(ok, err)
+	}
+
+	if ok, err := client.RpcbUnset(66606, 1, NetidTCP); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestPmapServerPersist(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "rpcbind")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "mappings")
+
+	s, err := NewPmapServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setMapping(t, s, RPCB{Program: 66611, Version: 1, Netid: NetidTCP, Addr: "0.0.0.0.8.1", Owner: "1234"})
+
+	// Mappings are loaded from the file
+	loaded, err := NewPmapServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner, ok := mappingOwner(loaded, 66611, 1, NetidTCP); !ok || owner != "1234" {
+		t.Fatalf("mappings loaded: %+v", loaded.Mappings())
+	}
+
+	// Changes which can't be persisted are rejected
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Set(RPCB{Program: 66611, Version: 2, Netid: NetidTCP, Addr: "0.0.0.0.8.1"}); ok || err == nil {
+		t.Fatal(ok, err)
+	}
+	if ok, err := s.Unset(66611, 1, ""); ok || err == nil {
+		t.Fatal(ok, err)
+	}
+	if m := s.Mappings(); len(m) != 1 || m[0].Version != 1 {
+		t.Fatalf("mappings changed: %+v", m)
+	}
+
+	// and reported to callers as a system error
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	client := &PmapClient{Host: l.Addr().String()}
+	if _, err := client.RpcbSet(66611, 2, NetidTCP, "127.0.0.1.8.1"); !errors.Is(err, ErrSystemErr) {
+		t.Fatalf("got error %v, want %v", err, ErrSystemErr)
+	}
+}
+
+func TestPmapServerPacket(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+
+	s, err := NewPmapServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setMapping(t, s, RPCB{Program: 66612, Version: 1, Netid: NetidUDP, Addr: "127.0.0.1.8.1"})
+	go s.ServePacket(pc)
+
+	// More lookups than workers are answered
+	errs := make(chan error, 4*pmapPacketWorkers)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			client := &PmapClient{Host: pc.LocalAddr().String(), Protocol: IPProtoUDP}
+			port, err := client.GetPort(66612, 1, IPProtoUDP)
+			if err == nil && port != 2049 {
+				err = errors.New("wrong port " + strconv.Itoa(int(port)))
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
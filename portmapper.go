@@ -5,11 +5,17 @@
 package sunrpc
 
 import (
+	"bytes"
 	"errors"
 	"net"
 	"net/rpc"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
 )
 
 const (
@@ -29,7 +35,25 @@ const (
 	IPProtoUDP Protocol = 17
 )
 
-var defaultAddress = "127.0.0.1:" + strconv.Itoa(pmapPort)
+// The portmapper on localhost is reached over IPv4 loopback by default and
+// over IPv6 loopback on hosts which have the portmapper listening only on
+// IPv6.
+var (
+	defaultAddress  = "127.0.0.1:" + strconv.Itoa(pmapPort)
+	defaultAddress6 = "[::1]:" + strconv.Itoa(pmapPort)
+)
+
+// pmapAddress returns the host with the portmapper port appended if host
+// doesn't specify a port. IPv6 addresses may or may not be enclosed in
+// square brackets when there's no port.
+func pmapAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(pmapPort))
+}
 
 // PortMapping is a mapping between (program, version, protocol) to port number
 type PortMapping struct {
@@ -41,51 +65,169 @@ type PortMapping struct {
 
 var registryInit sync.Once
 
-func initRegistry() {
+func registerPmapProcedures(programVersion uint32, remoteProcedures []string) {
 
 	procedureID := ProcedureID{
 		ProgramNumber:  portmapperProgramNumber,
-		ProgramVersion: portmapperProgramVersion,
+		ProgramVersion: programVersion,
 	}
 
-	// This is ordered as per procedure number
-	remoteProcedures := [6]string{
-		"Pmap.ProcNull", "Pmap.ProcSet", "Pmap.ProcUnset",
-		"Pmap.ProcGetPort", "Pmap.ProcDump", "Pmap.ProcCallIt"}
-
 	for id, procName := range remoteProcedures {
 		procedureID.ProcedureNumber = uint32(id)
 		_ = RegisterProcedure(Procedure{procedureID, procName}, true)
 	}
 }
 
-func initPmapClient(host string) *rpc.Client {
-	if host == "" {
-		host = defaultAddress
+func initRegistry() {
+
+	// This is ordered as per procedure number
+	registerPmapProcedures(portmapperProgramVersion, []string{
+		"Pmap.ProcNull", "Pmap.ProcSet", "Pmap.ProcUnset",
+		"Pmap.ProcGetPort", "Pmap.ProcDump", "Pmap.ProcCallIt"})
+
+	registerPmapProcedures(rpcbindVersion3, []string{
+		"RpcbV3.ProcNull", "RpcbV3.ProcSet", "RpcbV3.ProcUnset",
+		"RpcbV3.ProcGetAddr", "RpcbV3.ProcDump", "RpcbV3.ProcCallIt",
+		"RpcbV3.ProcGetTime", "RpcbV3.ProcUaddr2Taddr",
+		"RpcbV3.ProcTaddr2Uaddr"})
+
+	registerPmapProcedures(rpcbindVersion4, []string{
+		"RpcbV4.ProcNull", "RpcbV4.ProcSet", "RpcbV4.ProcUnset",
+		"RpcbV4.ProcGetAddr", "RpcbV4.ProcDump", "RpcbV4.ProcBcast",
+		"RpcbV4.ProcGetTime", "RpcbV4.ProcUaddr2Taddr",
+		"RpcbV4.ProcTaddr2Uaddr", "RpcbV4.ProcGetVersAddr",
+		"RpcbV4.ProcIndirect", "RpcbV4.ProcGetAddrList",
+		"RpcbV4.ProcGetStat"})
+}
+
+// PmapClient makes calls to the portmapper (or rpcbind) running on a host
+// over TCP or UDP, or on the local host over a Unix domain socket. The zero
+// value of PmapClient makes calls over TCP to the
+// portmapper on localhost. A PmapClient opens a new connection for every
+// call and can be used concurrently.
+type PmapClient struct {
+	// Host is the address of the portmapper in the form "host:port" or
+	// just "host" in which case port 111 is used. IPv6 addresses can be
+	// specified as "[::1]:111", "[::1]" or "::1". If empty, localhost is
+	// used over IPv4 and then IPv6.
+	Host string
+
+	// Protocol is the transport over which the portmapper is reached:
+	// IPProtoTCP (default) or IPProtoUDP.
+	Protocol Protocol
+
+	// Timeout is the total time to wait for a call to complete. Zero
+	// selects the default of 25 seconds.
+	Timeout time.Duration
+
+	// Retransmit is the time after which a call made over UDP is
+	// retransmitted if there's no reply yet. Zero selects the default of
+	// 5 seconds.
+	Retransmit time.Duration
+
+	// Socket is the path of the Unix domain socket of rpcbind on the local
+	// host, such as RpcbindSocket. If set, Host and Protocol are ignored.
+	// rpcbind trusts the identity of callers over the socket, so programs
+	// registered are owned by the user calling.
+	Socket string
+}
+
+// hosts returns the addresses of the portmapper to be tried in order.
+func (c *PmapClient) hosts() []string {
+	if c.Socket != "" {
+		return []string{c.Socket}
+	}
+	if c.Host == "" {
+		return []string{defaultAddress, defaultAddress6}
 	}
+	return []string{pmapAddress(c.Host)}
+}
+
+func (c *PmapClient) host() string {
+	return c.hosts()[0]
+}
+
+func (c *PmapClient) dial(host string) (*rpc.Client, error) {
 
 	registryInit.Do(initRegistry)
 
-	conn, err := net.Dial("tcp", host)
-	if err != nil {
-		return nil
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultUDPTimeout
+	}
+
+	if c.Socket != "" {
+		conn, err := net.DialTimeout("unix", host, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return NewClient(conn), nil
 	}
 
-	return rpc.NewClientWithCodec(NewClientCodec(conn, nil))
+	switch c.Protocol {
+	case IPProtoUDP:
+		conn, err := net.DialTimeout("udp", host, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return NewUDPClient(conn, timeout, c.Retransmit), nil
+	case 0, IPProtoTCP:
+		conn, err := net.DialTimeout("tcp", host, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return NewClient(conn), nil
+	}
+
+	return nil, ErrProtocolUnsupported
 }
 
-// PmapSet creates port mapping of the program specified. It return true on
-// success and false otherwise.
-func PmapSet(programNumber, programVersion uint32, protocol Protocol, port uint32) (bool, error) {
+// call makes a single call to the portmapper on a new connection. If the
+// portmapper on localhost refuses connection over IPv4, IPv6 is tried.
+func (c *PmapClient) call(serviceMethod string, args interface{}, reply interface{}) error {
 
-	var result bool
+	var err error
+	for _, host := range c.hosts() {
+		err = c.callHost(host, serviceMethod, args, reply)
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			break
+		}
+	}
 
-	client := initPmapClient("")
-	if client == nil {
-		return result, errors.New("Could not create pmap client")
+	return err
+}
+
+func (c *PmapClient) callHost(host string, serviceMethod string, args interface{}, reply interface{}) error {
+
+	client, err := c.dial(host)
+	if err != nil {
+		return err
 	}
 	defer client.Close()
 
+	err = WrapClient(client).Call(serviceMethod, args, reply)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+
+	return err
+}
+
+// Set creates port mapping of the program specified. It return true on
+// success and false otherwise.
+func (c *PmapClient) Set(programNumber, programVersion uint32, protocol Protocol, port uint32) (bool, error) {
+
+	var result bool
+
 	mapping := &PortMapping{
 		Program:  programNumber,
 		Version:  programVersion,
@@ -93,50 +235,38 @@ func PmapSet(programNumber, programVersion uint32, protocol Protocol, port uint3
 		Port:     port,
 	}
 
-	err := client.Call("Pmap.ProcSet", mapping, &result)
+	err := c.call("Pmap.ProcSet", mapping, &result)
 	return result, err
 }
 
-// PmapUnset will unregister the program specified. It returns true on success
+// Unset will unregister the program specified. It returns true on success
 // and false otherwise.
-func PmapUnset(programNumber, programVersion uint32) (bool, error) {
+func (c *PmapClient) Unset(programNumber, programVersion uint32) (bool, error) {
 
 	var result bool
 
-	client := initPmapClient("")
-	if client == nil {
-		return result, errors.New("Could not create pmap client")
-	}
-	defer client.Close()
-
 	mapping := &PortMapping{
 		Program: programNumber,
 		Version: programVersion,
 	}
 
-	err := client.Call("Pmap.ProcUnset", mapping, &result)
+	err := c.call("Pmap.ProcUnset", mapping, &result)
 	return result, err
 }
 
-// PmapGetPort returns the port number on which the program specified is
-// awaiting call requests. If host is empty string, localhost is used.
-func PmapGetPort(host string, programNumber, programVersion uint32, protocol Protocol) (uint32, error) {
+// GetPort returns the port number on which the program specified is
+// awaiting call requests.
+func (c *PmapClient) GetPort(programNumber, programVersion uint32, protocol Protocol) (uint32, error) {
 
 	var port uint32
 
-	client := initPmapClient(host)
-	if client == nil {
-		return port, errors.New("Could not create pmap client")
-	}
-	defer client.Close()
-
 	mapping := &PortMapping{
 		Program:  programNumber,
 		Version:  programVersion,
 		Protocol: uint32(protocol),
 	}
 
-	err := client.Call("Pmap.ProcGetPort", mapping, &port)
+	err := c.call("Pmap.ProcGetPort", mapping, &port)
 	return port, err
 }
 
@@ -145,24 +275,19 @@ type portMappingList struct {
 	Next *portMappingList `xdr:"optional"`
 }
 
-type getMapsReply struct {
+// PmapDumpReply is the reply of the portmapper's DUMP procedure.
+type PmapDumpReply struct {
 	Next *portMappingList `xdr:"optional"`
 }
 
-// PmapGetMaps returns a list of PortMapping entries present in portmapper's
-// database. If host is empty string, localhost is used.
-func PmapGetMaps(host string) ([]PortMapping, error) {
+// GetMaps returns a list of PortMapping entries present in portmapper's
+// database.
+func (c *PmapClient) GetMaps() ([]PortMapping, error) {
 
 	var mappings []PortMapping
-	var result getMapsReply
+	var result PmapDumpReply
 
-	client := initPmapClient(host)
-	if client == nil {
-		return nil, errors.New("Could not create pmap client")
-	}
-	defer client.Close()
-
-	err := client.Call("Pmap.ProcDump", nil, &result)
+	err := c.call("Pmap.ProcDump", nil, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -181,3 +306,90 @@ func PmapGetMaps(host string) ([]PortMapping, error) {
 
 	return mappings, nil
 }
+
+// PmapCallArgs contains the arguments of the portmapper's CALLIT procedure.
+// The args of the remote procedure are marshalled into Args.
+type PmapCallArgs struct {
+	Program   uint32
+	Version   uint32
+	Procedure uint32
+	Args      []byte
+}
+
+// PmapCallResult is the reply of the portmapper's CALLIT procedure. The
+// result of the remote procedure is marshalled into Result.
+type PmapCallResult struct {
+	Port   uint32
+	Result []byte
+}
+
+// CallIt makes an indirect call to the procedure specified through the
+// portmapper, without the caller having to know the port of the remote
+// program. The args are marshalled and passed on as opaque bytes by the
+// portmapper and the procedure-specific result is unmarshalled into reply.
+// It returns the port on which the remote program is listening.
+//
+// Note that the portmapper only forwards calls received over UDP to
+// programs registered for UDP and it does not reply at all if the remote
+// procedure fails.
+func (c *PmapClient) CallIt(programNumber, programVersion, procedureNumber uint32, args interface{}, reply interface{}) (uint32, error) {
+
+	var result PmapCallResult
+
+	var buf bytes.Buffer
+	if args != nil {
+		if _, err := xdr.Marshal(&buf, &args); err != nil {
+			return 0, err
+		}
+	}
+
+	callit := &PmapCallArgs{
+		Program:   programNumber,
+		Version:   programVersion,
+		Procedure: procedureNumber,
+		Args:      buf.Bytes(),
+	}
+
+	if err := c.call("Pmap.ProcCallIt", callit, &result); err != nil {
+		return 0, err
+	}
+
+	if reply != nil {
+		if _, err := xdr.Unmarshal(bytes.NewReader(result.Result), &reply); err != nil {
+			return result.Port, err
+		}
+	}
+
+	return result.Port, nil
+}
+
+// PmapSet creates port mapping of the program specified with the local
+// portmapper. It return true on success and false otherwise.
+func PmapSet(programNumber, programVersion uint32, protocol Protocol, port uint32) (bool, error) {
+	return new(PmapClient).Set(programNumber, programVersion, protocol, port)
+}
+
+// PmapUnset will unregister the program specified from the local portmapper.
+// It returns true on success and false otherwise.
+func PmapUnset(programNumber, programVersion uint32) (bool, error) {
+	return new(PmapClient).Unset(programNumber, programVersion)
+}
+
+// PmapGetPort returns the port number on which the program specified is
+// awaiting call requests. If host is empty string, localhost is used.
+func PmapGetPort(host string, programNumber, programVersion uint32, protocol Protocol) (uint32, error) {
+	return (&PmapClient{Host: host}).GetPort(programNumber, programVersion, protocol)
+}
+
+// PmapGetMaps returns a list of PortMapping entries present in portmapper's
+// database. If host is empty string, localhost is used.
+func PmapGetMaps(host string) ([]PortMapping, error) {
+	return (&PmapClient{Host: host}).GetMaps()
+}
+
+// PmapCallIt makes an indirect call over UDP to the procedure specified
+// through the portmapper on host. See PmapClient.CallIt for details. If
+// host is empty string, localhost is used.
+func PmapCallIt(host string, programNumber, programVersion, procedureNumber uint32, args interface{}, reply interface{}) (uint32, error) {
+	return (&PmapClient{Host: host, Protocol: IPProtoUDP}).CallIt(programNumber, programVersion, procedureNumber, args, reply)
+}
@@ -0,0 +1,125 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+var callItTestProc = ProcedureID{ProgramNumber: 66614, ProgramVersion: 1, ProcedureNumber: 1}
+
+type CallItTest struct{}
+
+func (CallItTest) Double(args int32, reply *int32) error {
+	*reply = 2 * args
+	return nil
+}
+
+// servePacketProgram serves rcvr over UDP on the local host and returns
+// the address of the socket.
+func servePacketProgram(t *testing.T, rcvr interface{}) *net.UDPAddr {
+	t.Helper()
+
+	server := rpc.NewServer()
+	if err := server.Register(rcvr); err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			server.ServeRequest(newDatagramServerCodec(pc, addr, append([]byte(nil), buf[:n]...)))
+		}
+	}()
+
+	return pc.LocalAddr().(*net.UDPAddr)
+}
+
+// servePmapPacket serves s over UDP on the local host and returns the
+// address of the socket.
+func servePmapPacket(t *testing.T, s *PmapServer) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go s.ServePacket(pc)
+
+	return pc.LocalAddr().String()
+}
+
+func TestPmapCallIt(t *testing.T) {
+	if err := RegisterProcedure(Procedure{callItTestProc, "CallItTest.Double"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := servePacketProgram(t, CallItTest{})
+	_, uaddr, err := FormatUniversalAddress(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewPmapServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setMapping(t, s, RPCB{Program: 66614, Version: 1, Netid: NetidUDP, Addr: uaddr})
+	host := servePmapPacket(t, s)
+
+	var reply int32
+	port, err := PmapCallIt(host, 66614, 1, 1, int32(21), &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != uint32(addr.Port) || reply != 42 {
+		t.Fatalf("got port %d and reply %d, want %d and 42", port, reply, addr.Port)
+	}
+
+	// The portmapper doesn't reply to calls of programs that aren't
+	// registered
+	client := &PmapClient{Host: host, Protocol: IPProtoUDP,
+		Timeout: 300 * time.Millisecond, Retransmit: 100 * time.Millisecond}
+	if _, err := client.CallIt(66615, 1, 1, int32(21), &reply); err != ErrTimeout {
+		t.Fatalf("got error %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestPmapClientTimeout(t *testing.T) {
+	// The portmapper accepts connections but never replies
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := &PmapClient{Host: l.Addr().String(), Timeout: 200 * time.Millisecond}
+	if _, err := client.GetPort(66614, 1, IPProtoTCP); err != ErrTimeout {
+		t.Fatalf("got error %v, want %v", err, ErrTimeout)
+	}
+}
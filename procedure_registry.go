@@ -35,13 +35,17 @@ type Procedure struct {
 
 // pMap is looked up in ServerCodec to map ProcedureID to method name.
 // rMap is looked up in ClientCodec to map method name to ProcedureID.
+// idempotent is looked up by reconnecting clients to find the calls that
+// can be made once more.
 var procedureRegistry = struct {
 	sync.RWMutex
-	pMap map[ProcedureID]string
-	rMap map[string]ProcedureID
+	pMap       map[ProcedureID]string
+	rMap       map[string]ProcedureID
+	idempotent map[ProcedureID]bool
 }{
-	pMap: make(map[ProcedureID]string),
-	rMap: make(map[string]ProcedureID),
+	pMap:       make(map[ProcedureID]string),
+	rMap:       make(map[string]ProcedureID),
+	idempotent: make(map[ProcedureID]bool),
 }
 
 func isExported(name string) bool {
@@ -103,6 +107,73 @@ func GetProcedureID(procedureName string) (ProcedureID, bool) {
 	return procedureID, ok
 }
 
+// SetIdempotent marks the procedures specified as idempotent: calling them
+// more than once has the same effect as calling them once. Calls to these
+// procedures that are awaiting reply when the connection of a reconnecting
+// client is lost are made once more. See WithReconnect.
+func SetIdempotent(procedureIDs ...ProcedureID) {
+	procedureRegistry.Lock()
+	defer procedureRegistry.Unlock()
+
+	for _, procedureID := range procedureIDs {
+		procedureRegistry.idempotent[procedureID] = true
+	}
+}
+
+// IsIdempotent returns true if the procedure is marked idempotent. The NULL
+// procedure (procedure number 0) of every program always is.
+func IsIdempotent(procedureID ProcedureID) bool {
+	if procedureID.ProcedureNumber == 0 {
+		return true
+	}
+
+	procedureRegistry.RLock()
+	defer procedureRegistry.RUnlock()
+
+	return procedureRegistry.idempotent[procedureID]
+}
+
+// programVersions returns the lowest and highest versions of the program
+// specified that have procedures in the registry. It also returns a bool
+// which is set to true only if the program is found in the registry.
+func programVersions(programNumber uint32) (uint32, uint32, bool) {
+	procedureRegistry.RLock()
+	defer procedureRegistry.RUnlock()
+
+	var low, high uint32
+	found := false
+	for procedureID := range procedureRegistry.pMap {
+		if procedureID.ProgramNumber != programNumber {
+			continue
+		}
+		if !found || procedureID.ProgramVersion < low {
+			low = procedureID.ProgramVersion
+		}
+		if !found || procedureID.ProgramVersion > high {
+			high = procedureID.ProgramVersion
+		}
+		found = true
+	}
+
+	return low, high, found
+}
+
+// hasProgramVersion returns true if the registry has any procedure of the
+// program and version specified.
+func hasProgramVersion(programNumber, programVersion uint32) bool {
+	procedureRegistry.RLock()
+	defer procedureRegistry.RUnlock()
+
+	for procedureID := range procedureRegistry.pMap {
+		if procedureID.ProgramNumber == programNumber &&
+			procedureID.ProgramVersion == programVersion {
+			return true
+		}
+	}
+
+	return false
+}
+
 // RemoveProcedure takes a string or ProcedureID struct as argument and deletes
 // the corresponding procedure from procedure registry.
 func RemoveProcedure(procedure interface{}) {
@@ -115,12 +186,14 @@ func RemoveProcedure(procedure interface{}) {
 		if ok {
 			delete(procedureRegistry.pMap, procedureID)
 			delete(procedureRegistry.rMap, p)
+			delete(procedureRegistry.idempotent, procedureID)
 		}
 	case ProcedureID:
 		procedureName, ok := procedureRegistry.pMap[p]
 		if ok {
 			delete(procedureRegistry.pMap, p)
 			delete(procedureRegistry.rMap, procedureName)
+			delete(procedureRegistry.idempotent, p)
 		}
 	}
 }
@@ -0,0 +1,157 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/rpc"
+	"sort"
+	"time"
+)
+
+// Time allowed for each attempt to connect again
+const redialTimeout = 30 * time.Second
+
+// WithReconnect makes the client connect again by calling dial when its
+// connection is lost, say because the server restarted, instead of shutting
+// down. dial is called with increasing delays till it succeeds or the client
+// is closed. The ctx passed to dial is done when the client is closed or
+// the attempt takes longer than 30 seconds. Calls awaiting reply to
+// procedures marked idempotent (see SetIdempotent) are then made once more
+// on the new connection with the same XID, so that the duplicate request
+// cache of the server can recognise them. Calls to other procedures fail
+// with ErrConnectionLost (see Client), as the server may have executed them
+// already.
+func WithReconnect(dial func(ctx context.Context) (io.ReadWriteCloser, error)) ClientOption {
+	return func(c *clientCodec) {
+		c.redial = dial
+		c.closing, c.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// DialReconnecting connects to a Sun-RPC server at the specified network
+// address, like Dial, and connects again when the connection is lost. See
+// WithReconnect.
+func DialReconnecting(network, address string, opts ...ClientOption) (*rpc.Client, error) {
+
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	conn, err := dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, WithReconnect(dial))
+	return rpc.NewClientWithCodec(NewClientCodec(conn, nil, opts...)), nil
+}
+
+// connLost records that the connection was lost and fails the calls
+// awaiting reply that can't be made once more. It returns false if the
+// connection was lost because the codec was closed.
+func (c *clientCodec) connLost() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.broken = true
+	for seq, call := range c.pending {
+		if !IsIdempotent(call.procedureID) {
+			c.lost = append(c.lost, seq)
+		}
+	}
+
+	return true
+}
+
+// nextLost removes a call failed for the lost connection from the pending
+// calls and returns it along with its Seq.
+func (c *clientCodec) nextLost() (uint64, pendingCall, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.lost) == 0 {
+		return 0, pendingCall{}, false
+	}
+
+	seq := c.lost[0]
+	c.lost = c.lost[1:]
+	call := c.pending[seq]
+	delete(c.pending, seq)
+
+	return seq, call, true
+}
+
+// reconnect replaces the lost connection, if any, and makes the calls
+// awaiting reply once more on the new connection. It returns true if the
+// connection was replaced. Calls that can't be made once more are failed
+// like those of the lost connection. If writing to the new connection
+// fails, it's closed and found lost by ReadResponseHeader(), which then
+// connects again. io.EOF is returned if the codec is closed meanwhile.
+func (c *clientCodec) reconnect() (bool, error) {
+
+	c.mutex.Lock()
+	broken := c.broken
+	c.mutex.Unlock()
+
+	if !broken {
+		return false, nil
+	}
+	c.conn.Close()
+
+	delay := minRedialDelay
+	for {
+		ctx, cancel := context.WithTimeout(c.closing, redialTimeout)
+		conn, err := c.redial(ctx)
+		cancel()
+		if err == nil {
+			c.mutex.Lock()
+			if c.closed {
+				c.mutex.Unlock()
+				conn.Close()
+				return false, io.EOF
+			}
+			c.conn = conn
+			c.broken = false
+			// Calls made from now on are written by WriteRequest()
+			seqs := make([]uint64, 0, len(c.pending))
+			for seq := range c.pending {
+				seqs = append(seqs, seq)
+			}
+			calls := make([]pendingCall, len(seqs))
+			sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+			for i, seq := range seqs {
+				calls[i] = c.pending[seq]
+			}
+			c.mutex.Unlock()
+
+			for i, seq := range seqs {
+				if err := c.writeCall(seq, calls[i]); err != nil {
+					c.mutex.Lock()
+					c.lost = append(c.lost, seq)
+					c.mutex.Unlock()
+				}
+			}
+			return true, nil
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-c.closing.Done():
+			return false, io.EOF
+		}
+		delay *= 2
+		if delay > maxRedialDelay {
+			delay = maxRedialDelay
+		}
+	}
+}
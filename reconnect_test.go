@@ -0,0 +1,312 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	reconnectIdemProc    = ProcedureID{ProgramNumber: 66608, ProgramVersion: 1, ProcedureNumber: 1}
+	reconnectNonIdemProc = ProcedureID{ProgramNumber: 66608, ProgramVersion: 1, ProcedureNumber: 2}
+)
+
+type ReconnectTestArgs struct {
+	A    int32
+	info *CallInfo
+}
+
+func (a *ReconnectTestArgs) SetCallInfo(info *CallInfo) { a.info = info }
+
+// reconnectCall is a call received by ReconnectTest.
+type reconnectCall struct {
+	proc uint32
+	xid  uint32
+}
+
+// ReconnectTest records the calls it receives and replies to them once
+// release is closed.
+type ReconnectTest struct {
+	calls   chan reconnectCall
+	release chan struct{}
+}
+
+func (r *ReconnectTest) serve(args *ReconnectTestArgs, reply *int32) error {
+	r.calls <- reconnectCall{args.info.ProcedureID.ProcedureNumber, args.info.Xid}
+	<-r.release
+	*reply = args.A
+	return nil
+}
+
+func (r *ReconnectTest) Idem(args *ReconnectTestArgs, reply *int32) error {
+	return r.serve(args, reply)
+}
+
+func (r *ReconnectTest) NonIdem(args *ReconnectTestArgs, reply *int32) error {
+	return r.serve(args, reply)
+}
+
+// reconnectServer serves ReconnectTest on listener till killed.
+type reconnectServer struct {
+	listener net.Listener
+	handler  *ReconnectTest
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func startReconnectServer(t *testing.T, address string, release chan struct{}) *reconnectServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &reconnectServer{
+		listener: listener,
+		handler:  &ReconnectTest{make(chan reconnectCall, 10), release},
+	}
+	server := rpc.NewServer()
+	if err := server.Register(s.handler); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+			go server.ServeCodec(NewServerCodec(conn, nil))
+		}
+	}()
+	t.Cleanup(s.kill)
+
+	return s
+}
+
+// kill closes the listener and all connections.
+func (s *reconnectServer) kill() {
+	s.listener.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *reconnectServer) call(t *testing.T) reconnectCall {
+	t.Helper()
+
+	select {
+	case call := <-s.handler.calls:
+		return call
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not received")
+	}
+	return reconnectCall{}
+}
+
+func registerReconnectTest(t *testing.T) {
+	for _, p := range []Procedure{
+		{reconnectIdemProc, "ReconnectTest.Idem"},
+		{reconnectNonIdemProc, "ReconnectTest.NonIdem"},
+	} {
+		if err := RegisterProcedure(p, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	SetIdempotent(reconnectIdemProc)
+}
+
+// serveReconnectTest serves handler on conn.
+func serveReconnectTest(t *testing.T, conn net.Conn, handler *ReconnectTest) {
+	server := rpc.NewServer()
+	if err := server.Register(handler); err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeCodec(NewServerCodec(conn, nil))
+}
+
+func TestReconnectReplay(t *testing.T) {
+	registerReconnectTest(t)
+
+	// The first server never replies
+	blocked := make(chan struct{})
+	defer close(blocked)
+	first := startReconnectServer(t, "127.0.0.1:0", blocked)
+	address := first.listener.Addr().String()
+
+	rpcClient, err := DialReconnecting("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+	client := WrapClient(rpcClient)
+
+	var idemReply, nonIdemReply int32
+	idem := client.Go("ReconnectTest.Idem", &ReconnectTestArgs{A: 1}, &idemReply, nil)
+	nonIdem := client.Go("ReconnectTest.NonIdem", &ReconnectTestArgs{A: 2}, &nonIdemReply, nil)
+
+	xids := make(map[uint32]uint32)
+	for i := 0; i < 2; i++ {
+		call := first.call(t)
+		xids[call.proc] = call.xid
+	}
+
+	// The server restarts
+	first.kill()
+
+	select {
+	case call := <-nonIdem.Done:
+		if call.Error != ErrConnectionLost {
+			t.Fatalf("got error %v, want %v", call.Error, ErrConnectionLost)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call to non-idempotent procedure not failed")
+	}
+
+	released := make(chan struct{})
+	close(released)
+	second := startReconnectServer(t, address, released)
+
+	// Only the call to the idempotent procedure is made once more, with
+	// the same XID
+	call := second.call(t)
+	if call.proc != reconnectIdemProc.ProcedureNumber || call.xid != xids[call.proc] {
+		t.Fatalf("got call %+v, want procedure %d with xid %#x", call,
+			reconnectIdemProc.ProcedureNumber, xids[reconnectIdemProc.ProcedureNumber])
+	}
+	select {
+	case call := <-idem.Done:
+		if call.Error != nil || idemReply != 1 {
+			t.Fatal(idemReply, call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call to idempotent procedure not completed")
+	}
+	select {
+	case call := <-second.handler.calls:
+		t.Fatalf("got unexpected call %+v", call)
+	default:
+	}
+
+	// The client keeps working on the new connection
+	var reply int32
+	if err := client.Call("ReconnectTest.NonIdem", &ReconnectTestArgs{A: 3}, &reply); err != nil || reply != 3 {
+		t.Fatal(reply, err)
+	}
+}
+
+func TestReconnectClose(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	dialed := make(chan context.Context, 1)
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		dialed <- ctx
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	client := rpc.NewClientWithCodec(NewClientCodec(clientConn, nil, WithReconnect(dial)))
+
+	// The connection is lost and the client tries to connect again
+	serverConn.Close()
+	var ctx context.Context
+	select {
+	case ctx = <-dialed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't connect again")
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		t.Fatal("attempt to connect again has no deadline")
+	}
+
+	// Closing the client abandons the attempt
+	client.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("attempt to connect again not cancelled by Close")
+	}
+}
+
+// writeFailConn fails all writes while reads block till it's closed.
+type writeFailConn struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *writeFailConn) Read(p []byte) (int, error) {
+	<-c.closed
+	return 0, io.EOF
+}
+
+func (c *writeFailConn) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func (c *writeFailConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestReconnectReplayWriteFails(t *testing.T) {
+	registerReconnectTest(t)
+
+	blocked := make(chan struct{})
+	defer close(blocked)
+	first := &ReconnectTest{make(chan reconnectCall, 10), blocked}
+	clientConn, serverConn := net.Pipe()
+	serveReconnectTest(t, serverConn, first)
+
+	// Making the call once more fails on the first new connection and
+	// succeeds on the second
+	released := make(chan struct{})
+	close(released)
+	second := &ReconnectTest{make(chan reconnectCall, 10), released}
+	dials := make(chan struct{}, 10)
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		dials <- struct{}{}
+		if len(dials) == 1 {
+			return &writeFailConn{closed: make(chan struct{})}, nil
+		}
+		clientConn, serverConn := net.Pipe()
+		serveReconnectTest(t, serverConn, second)
+		return clientConn, nil
+	}
+	rpcClient := rpc.NewClientWithCodec(NewClientCodec(clientConn, nil, WithReconnect(dial)))
+	defer rpcClient.Close()
+
+	var reply int32
+	call := WrapClient(rpcClient).Go("ReconnectTest.Idem", &ReconnectTestArgs{A: 1}, &reply, nil)
+	select {
+	case <-first.calls:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not received")
+	}
+	serverConn.Close()
+
+	select {
+	case call := <-call.Done:
+		if call.Error != nil || reply != 1 {
+			t.Fatal(reply, call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not made once more after failed write")
+	}
+	if n := len(dials); n != 2 {
+		t.Fatalf("connected %d times, want 2", n)
+	}
+}
@@ -32,6 +32,17 @@ const (
 
 	// Max size of RPC message that a client is allowed to send.
 	maxRecordSize = 1 * 1024 * 1024
+
+	// Max size of RPC message sent as a single UDP datagram.
+	maxDatagramSize = 64 * 1024
+
+	// Max number of fragments of a record. A well-behaved peer sends a
+	// handful of fragments at most, usually just one.
+	maxRecordFragments = 1024
+
+	// Max number of empty fragments that aren't the last fragment of a
+	// record. They carry no data and only cost the reader a header each.
+	maxEmptyFragments = 8
 )
 
 func isLastFragment(fragmentHeader uint32) bool {
@@ -61,8 +72,15 @@ func minOf(a, b int64) int64 {
 }
 
 // WriteFullRecord writes the fully formed RPC message reply to network
-// by breaking it into one or more record fragments.
+// by breaking it into one or more record fragments. It returns the number
+// of bytes of data written, which doesn't include the fragment headers.
 func WriteFullRecord(conn io.Writer, data []byte) (int64, error) {
+	return writeRecord(conn, data, maxRecordFragmentSize)
+}
+
+// writeRecord is WriteFullRecord with fragments of maxFragmentSize bytes
+// at most.
+func writeRecord(conn io.Writer, data []byte, maxFragmentSize int64) (int64, error) {
 
 	dataSize := int64(len(data))
 
@@ -72,20 +90,23 @@ func WriteFullRecord(conn io.Writer, data []byte) (int64, error) {
 	fragmentHeaderBytes := make([]byte, 4)
 	for {
 		remainingBytes := dataSize - totalBytesWritten
-		if remainingBytes <= maxRecordFragmentSize {
+		if remainingBytes <= maxFragmentSize {
 			lastFragment = true
 		}
-		fragmentSize := uint32(minOf(maxRecordFragmentSize, remainingBytes))
+		fragmentSize := minOf(maxFragmentSize, remainingBytes)
 
 		// Create fragment header
-		binary.BigEndian.PutUint32(fragmentHeaderBytes, createFragmentHeader(fragmentSize, lastFragment))
+		binary.BigEndian.PutUint32(fragmentHeaderBytes, createFragmentHeader(uint32(fragmentSize), lastFragment))
 
 		// Write fragment header and fragment body to network
-		bytesWritten, err := conn.Write(append(fragmentHeaderBytes, data[totalBytesWritten:fragmentSize]...))
+		fragment := data[totalBytesWritten : totalBytesWritten+fragmentSize]
+		bytesWritten, err := conn.Write(append(fragmentHeaderBytes, fragment...))
+		if bytesWritten > len(fragmentHeaderBytes) {
+			totalBytesWritten += int64(bytesWritten - len(fragmentHeaderBytes))
+		}
 		if err != nil {
-			return int64(totalBytesWritten), err
+			return totalBytesWritten, err
 		}
-		totalBytesWritten += int64(bytesWritten)
 
 		if lastFragment {
 			break
@@ -97,21 +118,46 @@ func WriteFullRecord(conn io.Writer, data []byte) (int64, error) {
 
 // ReadFullRecord reads the entire RPC message from network and returns a
 // a []byte sequence which contains the record.
+//
+// The errors returned are:
+//   - io.EOF if the connection was closed before the first byte of the
+//     record, which is how peers normally close connections.
+//   - io.ErrUnexpectedEOF if the connection was closed within the record.
+//   - ErrInvalidFragmentSize if a fragment is larger than a record may be.
+//   - ErrRPCMessageSizeExceeded if the fragments add up to more than a
+//     record may be.
+//   - ErrTooManyFragments if the record has more fragments than allowed,
+//     or more empty fragments that aren't the last one.
+//   - the error of conn, such as ErrRecordTimeout from the server.
+//
+// The stream is left in the middle of the record on error, so the
+// connection can't be used any further.
 func ReadFullRecord(conn io.Reader) ([]byte, error) {
 
 	// In almost all cases, RPC message contain only one fragment which
 	// is not too big in size.
 	record := new(bytes.Buffer)
 	var fragmentHeader uint32
+	fragments, emptyFragments := 0, 0
 	for {
 		// Read record fragment header
 		err := binary.Read(conn, binary.BigEndian, &fragmentHeader)
 		if err != nil {
+			if err == io.EOF && fragments > 0 {
+				err = io.ErrUnexpectedEOF
+			}
 			return nil, err
 		}
 
+		fragments++
+		if fragments > maxRecordFragments {
+			return nil, ErrTooManyFragments
+		}
+
+		// The 31-bit size can't exceed maxRecordFragmentSize. The limit
+		// that matters is the size of the record.
 		fragmentSize := getFragmentSize(fragmentHeader)
-		if fragmentSize > maxRecordFragmentSize {
+		if fragmentSize > maxRecordSize {
 			return nil, ErrInvalidFragmentSize
 		}
 
@@ -119,9 +165,19 @@ func ReadFullRecord(conn io.Reader) ([]byte, error) {
 			return nil, ErrRPCMessageSizeExceeded
 		}
 
+		if fragmentSize == 0 && !isLastFragment(fragmentHeader) {
+			emptyFragments++
+			if emptyFragments > maxEmptyFragments {
+				return nil, ErrTooManyFragments
+			}
+		}
+
 		// Copy fragment body (data) from network to buffer
 		bytesCopied, err := io.CopyN(record, conn, int64(fragmentSize))
-		if err != nil || (bytesCopied != int64(fragmentSize)) {
+		if bytesCopied != int64(fragmentSize) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return nil, err
 		}
 
@@ -0,0 +1,208 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// fragment returns a record fragment with the header specified, which may
+// not match the length of data.
+func fragment(size uint32, last bool, data []byte) []byte {
+	buf := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(buf, createFragmentHeader(size, last))
+	return append(buf, data...)
+}
+
+// fragments returns n fragments of size bytes, none of them the last.
+func fragments(n int, size uint32) []byte {
+	var buf []byte
+	for i := 0; i < n; i++ {
+		buf = append(buf, fragment(size, false, make([]byte, size))...)
+	}
+	return buf
+}
+
+// Malicious and malformed framings, and the error each is rejected with
+var recordFramings = []struct {
+	name  string
+	input []byte
+	err   error
+}{
+	{"empty stream", nil, io.EOF},
+	{"partial header", []byte{0x80, 0}, io.ErrUnexpectedEOF},
+	{"truncated fragment", fragment(10, true, []byte{1, 2, 3}), io.ErrUnexpectedEOF},
+	{"missing last fragment", fragment(2, false, []byte{1, 2}), io.ErrUnexpectedEOF},
+	{"largest fragment size", fragment(maxRecordFragmentSize, true, nil), ErrInvalidFragmentSize},
+	{"fragment larger than record", fragment(maxRecordSize+1, true, nil), ErrInvalidFragmentSize},
+	{"fragments larger than record", append(
+		fragment(maxRecordSize/2, false, make([]byte, maxRecordSize/2)),
+		fragment(maxRecordSize/2+1, true, nil)...), ErrRPCMessageSizeExceeded},
+	{"too many fragments", fragments(maxRecordFragments+1, 1), ErrTooManyFragments},
+	{"too many empty fragments", fragments(maxEmptyFragments+1, 0), ErrTooManyFragments},
+}
+
+func TestReadFullRecordErrors(t *testing.T) {
+	for _, tc := range recordFramings {
+		t.Run(tc.name, func(t *testing.T) {
+			record, err := ReadFullRecord(bytes.NewReader(tc.input))
+			if err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if record != nil {
+				t.Fatalf("got record of %d bytes on error", len(record))
+			}
+		})
+	}
+}
+
+func TestReadFullRecordConnError(t *testing.T) {
+	connErr := errors.New("conn failed")
+	conn := io.MultiReader(
+		bytes.NewReader(fragment(4, true, []byte{1, 2})),
+		iotestErrReader{connErr})
+
+	if _, err := ReadFullRecord(conn); err != connErr {
+		t.Fatalf("got error %v, want %v", err, connErr)
+	}
+}
+
+func TestReadFullRecordAccepted(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		record []byte
+	}{
+		{"empty record", fragment(0, true, nil), []byte{}},
+		{"single fragment", fragment(3, true, []byte{1, 2, 3}), []byte{1, 2, 3}},
+		{"empty fragments within limit", append(fragments(maxEmptyFragments, 0),
+			fragment(1, true, []byte{9})...), []byte{9}},
+		{"fragments within limit", append(fragments(maxRecordFragments-1, 1),
+			fragment(1, true, []byte{9})...), append(make([]byte, maxRecordFragments-1), 9)},
+		{"largest record", fragment(maxRecordSize, true, make([]byte, maxRecordSize)),
+			make([]byte, maxRecordSize)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			record, err := ReadFullRecord(bytes.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(record, tc.record) {
+				t.Fatalf("got record of %d bytes, want %d", len(record), len(tc.record))
+			}
+		})
+	}
+}
+
+func TestWriteRecordRoundTrip(t *testing.T) {
+	// Fragments are kept small as records with fragments of
+	// maxRecordFragmentSize bytes don't fit in memory.
+	const maxFragmentSize = 7
+
+	for _, size := range []int{0, 1, maxFragmentSize - 1, maxFragmentSize, maxFragmentSize + 1, 3*maxFragmentSize + 2} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i)
+		}
+
+		var buf bytes.Buffer
+		n, err := writeRecord(&buf, data, maxFragmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(size) {
+			t.Fatalf("size %d: wrote %d bytes of data", size, n)
+		}
+
+		// Every fragment but the last is full
+		wantFragments := (size + maxFragmentSize - 1) / maxFragmentSize
+		if wantFragments == 0 {
+			wantFragments = 1
+		}
+		if got := buf.Len() - size; got != 4*wantFragments {
+			t.Fatalf("size %d: got %d bytes of headers, want %d fragments", size, got, wantFragments)
+		}
+
+		record, err := ReadFullRecord(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(record, data) {
+			t.Fatalf("size %d: record differs after round trip", size)
+		}
+	}
+}
+
+func TestWriteFullRecordShortWrite(t *testing.T) {
+	writeErr := errors.New("write failed")
+	w := &limitedWriter{limit: 4 + 2, err: writeErr}
+
+	n, err := WriteFullRecord(w, []byte{1, 2, 3, 4, 5})
+	if err != writeErr {
+		t.Fatalf("got error %v, want %v", err, writeErr)
+	}
+	// The header isn't counted
+	if n != 2 {
+		t.Fatalf("got %d bytes written, want 2", n)
+	}
+}
+
+func FuzzReadFullRecord(f *testing.F) {
+	for _, tc := range recordFramings {
+		f.Add(tc.input)
+	}
+	f.Add(fragment(3, true, []byte{1, 2, 3}))
+	f.Add(append(fragment(1, false, []byte{1}), fragment(1, true, []byte{2})...))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		record, err := ReadFullRecord(bytes.NewReader(input))
+		switch err {
+		case nil:
+			if len(record) > maxRecordSize {
+				t.Fatalf("record of %d bytes accepted", len(record))
+			}
+			// The record survives being written out and read back
+			var buf bytes.Buffer
+			if _, err := WriteFullRecord(&buf, record); err != nil {
+				t.Fatal(err)
+			}
+			again, err := ReadFullRecord(&buf)
+			if err != nil || !bytes.Equal(again, record) {
+				t.Fatalf("round trip failed: %v", err)
+			}
+		case io.EOF, io.ErrUnexpectedEOF, ErrInvalidFragmentSize,
+			ErrRPCMessageSizeExceeded, ErrTooManyFragments:
+		default:
+			t.Fatalf("undocumented error %v", err)
+		}
+	})
+}
+
+// iotestErrReader fails every read with err.
+type iotestErrReader struct{ err error }
+
+func (r iotestErrReader) Read([]byte) (int, error) { return 0, r.err }
+
+// limitedWriter accepts limit bytes and then fails with err.
+type limitedWriter struct {
+	limit int
+	err   error
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.limit {
+		w.limit -= len(p)
+		return len(p), nil
+	}
+	n := w.limit
+	w.limit = 0
+	return n, w.err
+}
@@ -0,0 +1,273 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default interval at which registrations are verified to still exist
+const defaultRegistrationInterval = 30 * time.Second
+
+// Registrar registers programs with the portmapper (or rpcbind) and keeps
+// them registered for as long as the program is being served. The zero value
+// of Registrar registers with the portmapper on localhost.
+type Registrar struct {
+	// Client is used to reach the portmapper.
+	Client PmapClient
+
+	// Interval is how often the registrations are verified to still exist
+	// with the portmapper. They are registered again if they don't, which
+	// happens when the portmapper is restarted. Zero selects the default of
+	// 30 seconds and a negative value disables verification.
+	Interval time.Duration
+}
+
+// Registration is a set of (program, version, netid, address) mappings
+// registered with the portmapper by Registrar.
+type Registration struct {
+	registrar Registrar
+	bindings  []RPCB
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex sync.Mutex // protects err
+	err   error      // error unregistering mappings
+}
+
+// registrationBindings returns the mappings for all programs listening on
+// addr. A listener on the IPv6 wildcard address also accepts IPv4
+// connections, so such programs are registered for both.
+func registrationBindings(addr net.Addr, programs []Program) ([]RPCB, error) {
+
+	addrs := []net.Addr{addr}
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if a.IP.Equal(net.IPv6unspecified) && a.IP.To4() == nil {
+			addrs = append(addrs, &net.TCPAddr{IP: net.IPv4zero, Port: a.Port})
+		}
+	case *net.UDPAddr:
+		if a.IP.Equal(net.IPv6unspecified) && a.IP.To4() == nil {
+			addrs = append(addrs, &net.UDPAddr{IP: net.IPv4zero, Port: a.Port})
+		}
+	}
+
+	var bindings []RPCB
+	for _, a := range addrs {
+		netid, uaddr, err := FormatUniversalAddress(a)
+		if err != nil {
+			return nil, err
+		}
+		for _, program := range programs {
+			bindings = append(bindings, RPCB{
+				Program: program.Number(),
+				Version: program.Version(),
+				Netid:   netid,
+				Addr:    uaddr,
+				Owner:   rpcbOwner(),
+			})
+		}
+	}
+
+	return bindings, nil
+}
+
+// Register registers all programs specified as listening on addr, which is
+// usually the address returned by net.Listener.Addr() or
+// net.PacketConn.LocalAddr(). Stale mappings of the programs left behind
+// by an earlier process are replaced. The mappings are removed when ctx is
+// done or Close() is called on the Registration returned.
+//
+// Mappings on transports which the portmapper cannot express, such as IPv6
+// when only portmapper version 2 is available, are skipped.
+func (r *Registrar) Register(ctx context.Context, addr net.Addr, programs ...Program) (*Registration, error) {
+
+	bindings, err := registrationBindings(addr, programs)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &Registration{
+		registrar: *r,
+		done:      make(chan struct{}),
+	}
+
+	for _, binding := range bindings {
+		err := reg.set(binding)
+		if err == ErrNetidUnsupported {
+			continue
+		}
+		if err != nil {
+			reg.unsetAll()
+			return nil, err
+		}
+		reg.bindings = append(reg.bindings, binding)
+	}
+
+	ctx, reg.cancel = context.WithCancel(ctx)
+	go reg.monitor(ctx)
+
+	return reg, nil
+}
+
+// ListenAndRegister listens on the network address specified and registers
+// all programs with the portmapper as listening on it. If the port in
+// address is 0 or missing, as in ":0", an ephemeral port is chosen
+// automatically. Network must be "tcp", "tcp4", "tcp6" or "unix".
+func (r *Registrar) ListenAndRegister(ctx context.Context, network, address string, programs ...Program) (net.Listener, *Registration, error) {
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reg, err := r.Register(ctx, listener.Addr(), programs...)
+	if err != nil {
+		listener.Close()
+		return nil, nil, err
+	}
+
+	return listener, reg, nil
+}
+
+// RegisterListener registers all programs specified with the portmapper on
+// localhost as listening on listener. See Registrar.Register for details.
+func RegisterListener(ctx context.Context, listener net.Listener, programs ...Program) (*Registration, error) {
+	return new(Registrar).Register(ctx, listener.Addr(), programs...)
+}
+
+// Bindings returns the mappings registered with the portmapper.
+func (reg *Registration) Bindings() []RPCB {
+	return append([]RPCB(nil), reg.bindings...)
+}
+
+// Done returns a channel that is closed once the mappings have been
+// unregistered.
+func (reg *Registration) Done() <-chan struct{} {
+	return reg.done
+}
+
+// Close unregisters all mappings from the portmapper and stops verifying
+// them. It returns the error, if any, encountered while unregistering.
+func (reg *Registration) Close() error {
+	reg.cancel()
+	<-reg.done
+
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	return reg.err
+}
+
+func (reg *Registration) set(binding RPCB) error {
+
+	client := &reg.registrar.Client
+
+	// Remove stale mapping left behind, if any. A mapping to the same
+	// address is kept, as portmapper version 2 unsets the program on all
+	// protocols, including those just registered.
+	registered, same, err := reg.lookup(binding)
+	if err == nil && same {
+		return nil
+	}
+	if err == nil && registered {
+		_, _ = client.RpcbUnset(binding.Program, binding.Version, binding.Netid)
+	}
+
+	ok, err := client.RpcbSet(binding.Program, binding.Version, binding.Netid, binding.Addr)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPmapSetFailed
+	}
+
+	return nil
+}
+
+// exists returns true if the mapping is still registered with the
+// portmapper.
+func (reg *Registration) exists(binding RPCB) (bool, error) {
+	_, same, err := reg.lookup(binding)
+	return same, err
+}
+
+// lookup returns whether the program and version of the mapping are
+// registered with the portmapper for its netid and, if so, whether at the
+// address of the mapping.
+func (reg *Registration) lookup(binding RPCB) (bool, bool, error) {
+
+	uaddr, err := reg.registrar.Client.RpcbGetAddr(binding.Program, binding.Version, binding.Netid)
+	if err != nil || uaddr == "" {
+		return false, false, err
+	}
+
+	// The portmapper replaces wildcard address with its own, so only the
+	// port can be compared.
+	registered, err := ParseUniversalAddress(binding.Netid, uaddr)
+	if err != nil {
+		return true, false, err
+	}
+	expected, err := ParseUniversalAddress(binding.Netid, binding.Addr)
+	if err != nil {
+		return true, false, err
+	}
+	if binding.Netid == NetidLocal {
+		return true, registered.String() == expected.String(), nil
+	}
+
+	return true, addrPort(registered) == addrPort(expected), nil
+}
+
+func (reg *Registration) unsetAll() error {
+	var err error
+	for _, binding := range reg.bindings {
+		if _, e := reg.registrar.Client.RpcbUnset(binding.Program, binding.Version, binding.Netid); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// monitor verifies the mappings periodically and unregisters them when ctx
+// is done.
+func (reg *Registration) monitor(ctx context.Context) {
+
+	defer close(reg.done)
+
+	interval := reg.registrar.Interval
+	if interval == 0 {
+		interval = defaultRegistrationInterval
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			err := reg.unsetAll()
+			reg.mutex.Lock()
+			reg.err = err
+			reg.mutex.Unlock()
+			return
+		case <-tick:
+			for _, binding := range reg.bindings {
+				ok, err := reg.exists(binding)
+				if err == nil && !ok {
+					// The portmapper was probably restarted
+					_ = reg.set(binding)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// registrationProgram is a Program with no procedures.
+type registrationProgram uint32
+
+func (p registrationProgram) Name() string            { return "RegistrationTest" }
+func (p registrationProgram) Number() uint32          { return uint32(p) }
+func (p registrationProgram) Version() uint32         { return 1 }
+func (p registrationProgram) Procedures() []Procedure { return nil }
+
+// mappingAddr returns the universal address of the mapping specified and
+// empty string if there's none.
+func mappingAddr(s *PmapServer, programNumber uint32, netid string) string {
+	for _, m := range s.Mappings() {
+		if m.Program == programNumber && m.Version == 1 && m.Netid == netid {
+			return m.Addr
+		}
+	}
+	return ""
+}
+
+// listenerUaddr returns the universal address of l.
+func listenerUaddr(t *testing.T, l net.Listener) string {
+	t.Helper()
+
+	_, uaddr, err := FormatUniversalAddress(l.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uaddr
+}
+
+func listenTCP(t *testing.T) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestRegistrarRegister(t *testing.T) {
+	s, host := servePmap(t, "127.0.0.1:0")
+	r := &Registrar{Client: PmapClient{Host: host}, Interval: -1}
+	l := listenTCP(t)
+
+	// A stale mapping left behind is replaced, a mapping to the same
+	// address is kept
+	setMapping(t, s, RPCB{Program: 66616, Version: 1, Netid: NetidTCP, Addr: "127.0.0.1.8.1", Owner: unknownOwner})
+	setMapping(t, s, RPCB{Program: 66617, Version: 1, Netid: NetidTCP, Addr: listenerUaddr(t, l), Owner: unknownOwner})
+
+	reg, err := r.Register(context.Background(), l.Addr(), registrationProgram(66616), registrationProgram(66617))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Bindings()) != 2 {
+		t.Fatalf("got bindings %+v, want 2", reg.Bindings())
+	}
+	for _, program := range []uint32{66616, 66617} {
+		if uaddr := mappingAddr(s, program, NetidTCP); uaddr != listenerUaddr(t, l) {
+			t.Fatalf("program %d registered at %q, want %q", program, uaddr, listenerUaddr(t, l))
+		}
+	}
+
+	// Close unregisters all mappings
+	if err := reg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reg.Done():
+	default:
+		t.Fatal("Done not closed after Close")
+	}
+	if m := s.Mappings(); len(m) != 0 {
+		t.Fatalf("mappings left after Close: %+v", m)
+	}
+}
+
+func TestRegistrarContext(t *testing.T) {
+	s, host := servePmap(t, "127.0.0.1:0")
+	r := &Registrar{Client: PmapClient{Host: host}, Interval: -1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, reg, err := r.ListenAndRegister(ctx, "tcp", "127.0.0.1:0", registrationProgram(66618))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if uaddr := mappingAddr(s, 66618, NetidTCP); uaddr != listenerUaddr(t, l) {
+		t.Fatalf("registered at %q, want %q", uaddr, listenerUaddr(t, l))
+	}
+
+	// The mappings are removed once ctx is done
+	cancel()
+	select {
+	case <-reg.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("mappings not unregistered after ctx is done")
+	}
+	if m := s.Mappings(); len(m) != 0 {
+		t.Fatalf("mappings left after ctx is done: %+v", m)
+	}
+}
+
+func TestRegistrarMonitor(t *testing.T) {
+	first, err := NewPmapServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pmapListener := listenTCP(t)
+	go first.Serve(pmapListener)
+	host := pmapListener.Addr().String()
+
+	r := &Registrar{Client: PmapClient{Host: host}, Interval: 50 * time.Millisecond}
+	l := listenTCP(t)
+	reg, err := r.Register(context.Background(), l.Addr(), registrationProgram(66619))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+
+	// The portmapper restarts on the same address and loses the mappings,
+	// which are registered again
+	pmapListener.Close()
+	second, _ := servePmap(t, host)
+	deadline := time.Now().Add(5 * time.Second)
+	for mappingAddr(second, 66619, NetidTCP) != listenerUaddr(t, l) {
+		if time.Now().After(deadline) {
+			t.Fatal("mapping not registered again after portmapper restart")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
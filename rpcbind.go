@@ -0,0 +1,515 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+From RFC 1833 (https://tools.ietf.org/html/rfc1833)
+
+   The RPCBIND program number is the same as that of PMAP (100000). Version
+   3 and 4 of the protocol use a transport-independent format for the
+   transport address, known as the universal address format, and identify
+   transports by their network identifier (netid) instead of protocol
+   number. A server that supports only version 2 of the protocol replies
+   with PROG_MISMATCH to calls made with version 3 or 4.
+*/
+
+const (
+	rpcbindVersion3 = 3
+	rpcbindVersion4 = 4
+
+	// Number of procedures in rpcbind version 4 plus one
+	rpcbStatHighProc = 13
+	// Statistics are kept for rpcbind versions 2, 3 and 4
+	rpcbVersStat = 3
+)
+
+// RPCB is a mapping between (program, version, netid) to the universal
+// address on which the program is awaiting call requests. This is used by
+// rpcbind protocol versions 3 and 4.
+type RPCB struct {
+	Program uint32
+	Version uint32
+	Netid   string // network id. Example: "tcp", "udp6"
+	Addr    string // universal address. Example: "10.0.0.1.8.1"
+	Owner   string // owner of this service
+}
+
+type rpcbList struct {
+	Map  RPCB
+	Next *rpcbList `xdr:"optional"`
+}
+
+// RPCBDumpReply is the reply of the rpcbind's DUMP procedure.
+type RPCBDumpReply struct {
+	Next *rpcbList `xdr:"optional"`
+}
+
+// RPCBEntry contains a merged address of a service on a particular transport,
+// along with the transport's properties. It is returned by RpcbGetAddrList.
+type RPCBEntry struct {
+	MAddr       string // merged address of the service
+	Netid       string // netid field
+	Semantics   uint32 // semantics of the transport
+	ProtoFamily string // protocol family
+	Proto       string // protocol name
+}
+
+type rpcbEntryList struct {
+	Entry RPCBEntry
+	Next  *rpcbEntryList `xdr:"optional"`
+}
+
+// RPCBEntryListReply is the reply of the rpcbind's GETADDRLIST procedure.
+type RPCBEntryListReply struct {
+	Next *rpcbEntryList `xdr:"optional"`
+}
+
+// RPCBRmtCallResult is the reply of the rpcbind's CALLIT, BCAST and
+// INDIRECT procedures. The result of the remote procedure is marshalled
+// into Result.
+type RPCBRmtCallResult struct {
+	Addr   string
+	Result []byte
+}
+
+// RPCBStatAddr contains the statistics of GETPORT and GETADDR calls made
+// for a (program, version, netid).
+type RPCBStatAddr struct {
+	Program uint32
+	Version uint32
+	Success int32
+	Failure int32
+	Netid   string
+}
+
+type rpcbStatAddrList struct {
+	Stat RPCBStatAddr
+	Next *rpcbStatAddrList `xdr:"optional"`
+}
+
+// RPCBStatRmtCall contains the statistics of remote (indirect) calls made
+// for a (program, version, procedure, netid).
+type RPCBStatRmtCall struct {
+	Program   uint32
+	Version   uint32
+	Procedure uint32
+	Success   int32
+	Failure   int32
+	Indirect  int32 // whether callit or indirect
+	Netid     string
+}
+
+type rpcbStatRmtCallList struct {
+	Stat RPCBStatRmtCall
+	Next *rpcbStatRmtCallList `xdr:"optional"`
+}
+
+// RPCBStat contains the statistics of one version of the rpcbind protocol
+// as kept by the rpcbind server.
+type RPCBStat struct {
+	Info      [rpcbStatHighProc]int32 // number of calls to each procedure
+	SetInfo   int32
+	UnsetInfo int32
+	AddrInfo  []RPCBStatAddr
+	RmtInfo   []RPCBStatRmtCall
+}
+
+type rpcbStat struct {
+	Info      [rpcbStatHighProc]int32
+	SetInfo   int32
+	UnsetInfo int32
+	AddrInfo  *rpcbStatAddrList    `xdr:"optional"`
+	RmtInfo   *rpcbStatRmtCallList `xdr:"optional"`
+}
+
+// rpcbCall makes the call to the rpcbind procedure specified trying each of
+// the protocol versions in the order specified till the server accepts one.
+func (c *PmapClient) rpcbCall(procName string, args interface{}, reply interface{}, versions ...uint32) error {
+
+	var err error
+	for _, version := range versions {
+		err = c.call(fmt.Sprintf("RpcbV%d.%s", version, procName), args, reply)
+		if !isPmapOnly(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// isPmapOnly returns true if the error returned by rpcbCall indicates that
+// the server supports only version 2 of the protocol (portmapper).
+func isPmapOnly(err error) bool {
+	return errors.As(err, new(ErrProgMismatch))
+}
+
+// pmapHostIP returns the IP address of the host which has the portmapper
+// running, as used when converting replies of version 2 into universal
+// addresses. It returns an error if the host can't be resolved.
+func pmapHostIP(host string) (net.IP, error) {
+	if strings.HasPrefix(host, "/") {
+		// Unix domain socket of the portmapper on the local host
+		return net.IPv4(127, 0, 0, 1), nil
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+
+	addr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP, nil
+}
+
+func rpcbOwner() string {
+	return strconv.Itoa(os.Getuid())
+}
+
+// RpcbSet registers the program specified with rpcbind using the netid
+// and universal address specified. It falls back to using the portmapper
+// (version 2) protocol if rpcbind isn't available. It returns true on
+// success and false otherwise.
+func (c *PmapClient) RpcbSet(programNumber, programVersion uint32, netid, uaddr string) (bool, error) {
+
+	var result bool
+
+	binding := &RPCB{
+		Program: programNumber,
+		Version: programVersion,
+		Netid:   netid,
+		Addr:    uaddr,
+		Owner:   rpcbOwner(),
+	}
+
+	err := c.rpcbCall("ProcSet", binding, &result, rpcbindVersion4, rpcbindVersion3)
+	if !isPmapOnly(err) {
+		return result, err
+	}
+
+	protocol, err := NetidToProtocol(netid)
+	if err != nil {
+		return false, err
+	}
+	addr, err := ParseUniversalAddress(netid, uaddr)
+	if err != nil {
+		return false, err
+	}
+
+	return c.Set(programNumber, programVersion, protocol, uint32(addrPort(addr)))
+}
+
+// RpcbSetNetAddr registers the program specified with rpcbind as listening
+// on addr, which can be an IPv4, IPv6 or Unix domain socket address such as
+// the one returned by net.Listener.Addr(). The netid (for example "tcp6") is
+// derived from the address.
+func (c *PmapClient) RpcbSetNetAddr(programNumber, programVersion uint32, addr net.Addr) (bool, error) {
+
+	netid, uaddr, err := FormatUniversalAddress(addr)
+	if err != nil {
+		return false, err
+	}
+
+	return c.RpcbSet(programNumber, programVersion, netid, uaddr)
+}
+
+// RpcbUnset will unregister the program specified from rpcbind. If netid
+// is empty string, the program is unregistered from all transports. It
+// falls back to using the portmapper (version 2) protocol if rpcbind isn't
+// available. It returns true on success and false otherwise.
+func (c *PmapClient) RpcbUnset(programNumber, programVersion uint32, netid string) (bool, error) {
+
+	var result bool
+
+	binding := &RPCB{
+		Program: programNumber,
+		Version: programVersion,
+		Netid:   netid,
+		Owner:   rpcbOwner(),
+	}
+
+	err := c.rpcbCall("ProcUnset", binding, &result, rpcbindVersion4, rpcbindVersion3)
+	if !isPmapOnly(err) {
+		return result, err
+	}
+
+	return c.Unset(programNumber, programVersion)
+}
+
+// RpcbGetAddr returns the universal address on which the program specified
+// is awaiting call requests over the transport identified by netid. An empty
+// string is returned if the program isn't registered. It falls back to using
+// the portmapper (version 2) protocol if rpcbind isn't available, in which
+// case the program is taken to be listening on the address of the host, and
+// ErrNetidUnsupported is returned if netid isn't of the family of that
+// address.
+func (c *PmapClient) RpcbGetAddr(programNumber, programVersion uint32, netid string) (string, error) {
+
+	uaddr, err := c.rpcbGetAddr("ProcGetAddr", programNumber, programVersion, netid,
+		rpcbindVersion4, rpcbindVersion3)
+	if !isPmapOnly(err) {
+		return uaddr, err
+	}
+
+	protocol, err := NetidToProtocol(strings.TrimSuffix(netid, "6"))
+	if err != nil {
+		return "", err
+	}
+	ip, err := pmapHostIP(c.host())
+	if err != nil {
+		return "", err
+	}
+	if (ip.To4() == nil) != strings.HasSuffix(netid, "6") {
+		return "", ErrNetidUnsupported
+	}
+
+	port, err := c.GetPort(programNumber, programVersion, protocol)
+	if err != nil || port == 0 {
+		return "", err
+	}
+
+	_, uaddr, err = FormatUniversalAddress(protocolAddr(protocol, ip, int(port)))
+	return uaddr, err
+}
+
+// RpcbGetVersAddr is similar to RpcbGetAddr but returns the address only
+// if the exact version of the program specified is registered. This is
+// available only in version 4 of rpcbind protocol and it falls back to
+// RpcbGetAddr if the server doesn't support it.
+func (c *PmapClient) RpcbGetVersAddr(programNumber, programVersion uint32, netid string) (string, error) {
+
+	uaddr, err := c.rpcbGetAddr("ProcGetVersAddr", programNumber, programVersion, netid,
+		rpcbindVersion4)
+	if !isPmapOnly(err) {
+		return uaddr, err
+	}
+
+	return c.RpcbGetAddr(programNumber, programVersion, netid)
+}
+
+// RpcbGetNetAddr returns the address on which the program specified is
+// awaiting call requests over the transport identified by netid (for
+// example "tcp6" or "udp6"). A nil address is returned if the program isn't
+// registered.
+func (c *PmapClient) RpcbGetNetAddr(programNumber, programVersion uint32, netid string) (net.Addr, error) {
+
+	uaddr, err := c.RpcbGetAddr(programNumber, programVersion, netid)
+	if err != nil || uaddr == "" {
+		return nil, err
+	}
+
+	return ParseUniversalAddress(netid, uaddr)
+}
+
+func (c *PmapClient) rpcbGetAddr(procName string, programNumber, programVersion uint32, netid string, versions ...uint32) (string, error) {
+
+	var uaddr string
+
+	binding := &RPCB{
+		Program: programNumber,
+		Version: programVersion,
+		Netid:   netid,
+	}
+
+	err := c.rpcbCall(procName, binding, &uaddr, versions...)
+	return uaddr, err
+}
+
+// RpcbDump returns a list of RPCB entries present in rpcbind's database. It
+// falls back to using the portmapper (version 2) protocol if rpcbind isn't
+// available, in which case the programs are taken to be listening on the
+// address of the host.
+func (c *PmapClient) RpcbDump() ([]RPCB, error) {
+
+	var bindings []RPCB
+	var result RPCBDumpReply
+
+	err := c.rpcbCall("ProcDump", nil, &result, rpcbindVersion4, rpcbindVersion3)
+	if isPmapOnly(err) {
+		ip, err := pmapHostIP(c.host())
+		if err != nil {
+			return nil, err
+		}
+		mappings, err := c.GetMaps()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range mappings {
+			netid, uaddr, _ := FormatUniversalAddress(
+				protocolAddr(Protocol(m.Protocol), ip, int(m.Port)))
+			bindings = append(bindings, RPCB{
+				Program: m.Program,
+				Version: m.Version,
+				Netid:   netid,
+				Addr:    uaddr,
+			})
+		}
+		return bindings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for trav := result.Next; trav != nil; trav = trav.Next {
+		bindings = append(bindings, trav.Map)
+	}
+
+	return bindings, nil
+}
+
+// RpcbGetTime returns the local time on the host running rpcbind. This is
+// not supported by the portmapper (version 2) protocol.
+func (c *PmapClient) RpcbGetTime() (time.Time, error) {
+
+	var seconds uint32
+
+	err := c.rpcbCall("ProcGetTime", nil, &seconds, rpcbindVersion4, rpcbindVersion3)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(int64(seconds), 0), nil
+}
+
+// RpcbGetAddrList returns the list of addresses on which the program
+// specified is awaiting call requests over transports having the same
+// protocol family as the transport identified by netid. This is available
+// only in version 4 of rpcbind protocol and falls back to a list of at most
+// one entry returned by RpcbGetAddr if the server doesn't support it.
+func (c *PmapClient) RpcbGetAddrList(programNumber, programVersion uint32, netid string) ([]RPCBEntry, error) {
+
+	var entries []RPCBEntry
+	var result RPCBEntryListReply
+
+	binding := &RPCB{
+		Program: programNumber,
+		Version: programVersion,
+		Netid:   netid,
+	}
+
+	err := c.rpcbCall("ProcGetAddrList", binding, &result, rpcbindVersion4)
+	if isPmapOnly(err) {
+		uaddr, err := c.RpcbGetAddr(programNumber, programVersion, netid)
+		if err != nil || uaddr == "" {
+			return nil, err
+		}
+		return []RPCBEntry{{MAddr: uaddr, Netid: netid}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for trav := result.Next; trav != nil; trav = trav.Next {
+		entries = append(entries, trav.Entry)
+	}
+
+	return entries, nil
+}
+
+// RpcbGetStat returns the statistics kept by the rpcbind server for each of
+// versions 2, 3 and 4 (in that order) of the protocol. This is available only
+// in version 4 of rpcbind protocol.
+func (c *PmapClient) RpcbGetStat() ([]RPCBStat, error) {
+
+	var result [rpcbVersStat]rpcbStat
+
+	if err := c.rpcbCall("ProcGetStat", nil, &result, rpcbindVersion4); err != nil {
+		return nil, err
+	}
+
+	stats := make([]RPCBStat, rpcbVersStat)
+	for i, s := range result {
+		stats[i].Info = s.Info
+		stats[i].SetInfo = s.SetInfo
+		stats[i].UnsetInfo = s.UnsetInfo
+		for trav := s.AddrInfo; trav != nil; trav = trav.Next {
+			stats[i].AddrInfo = append(stats[i].AddrInfo, trav.Stat)
+		}
+		for trav := s.RmtInfo; trav != nil; trav = trav.Next {
+			stats[i].RmtInfo = append(stats[i].RmtInfo, trav.Stat)
+		}
+	}
+
+	return stats, nil
+}
+
+// RpcbSet registers the program specified with the local rpcbind server. See
+// PmapClient.RpcbSet for details.
+func RpcbSet(programNumber, programVersion uint32, netid, uaddr string) (bool, error) {
+	return new(PmapClient).RpcbSet(programNumber, programVersion, netid, uaddr)
+}
+
+// RpcbSetNetAddr registers the program specified with the local rpcbind
+// server as listening on addr. See PmapClient.RpcbSetNetAddr for details.
+func RpcbSetNetAddr(programNumber, programVersion uint32, addr net.Addr) (bool, error) {
+	return new(PmapClient).RpcbSetNetAddr(programNumber, programVersion, addr)
+}
+
+// RpcbUnset will unregister the program specified from the local rpcbind
+// server. See PmapClient.RpcbUnset for details.
+func RpcbUnset(programNumber, programVersion uint32, netid string) (bool, error) {
+	return new(PmapClient).RpcbUnset(programNumber, programVersion, netid)
+}
+
+// RpcbGetAddr returns the universal address of the program specified. See
+// PmapClient.RpcbGetAddr for details. If host is empty string, localhost is
+// used.
+func RpcbGetAddr(host string, programNumber, programVersion uint32, netid string) (string, error) {
+	return (&PmapClient{Host: host}).RpcbGetAddr(programNumber, programVersion, netid)
+}
+
+// RpcbGetNetAddr returns the address of the program specified. See
+// PmapClient.RpcbGetNetAddr for details. If host is empty string, localhost
+// is used.
+func RpcbGetNetAddr(host string, programNumber, programVersion uint32, netid string) (net.Addr, error) {
+	return (&PmapClient{Host: host}).RpcbGetNetAddr(programNumber, programVersion, netid)
+}
+
+// RpcbGetVersAddr returns the universal address of the exact version of the
+// program specified. See PmapClient.RpcbGetVersAddr for details. If host is
+// empty string, localhost is used.
+func RpcbGetVersAddr(host string, programNumber, programVersion uint32, netid string) (string, error) {
+	return (&PmapClient{Host: host}).RpcbGetVersAddr(programNumber, programVersion, netid)
+}
+
+// RpcbDump returns a list of RPCB entries present in rpcbind's database. If
+// host is empty string, localhost is used.
+func RpcbDump(host string) ([]RPCB, error) {
+	return (&PmapClient{Host: host}).RpcbDump()
+}
+
+// RpcbGetTime returns the local time on the host running rpcbind. If host is
+// empty string, localhost is used.
+func RpcbGetTime(host string) (time.Time, error) {
+	return (&PmapClient{Host: host}).RpcbGetTime()
+}
+
+// RpcbGetAddrList returns the list of addresses of the program specified.
+// See PmapClient.RpcbGetAddrList for details. If host is empty string,
+// localhost is used.
+func RpcbGetAddrList(host string, programNumber, programVersion uint32, netid string) ([]RPCBEntry, error) {
+	return (&PmapClient{Host: host}).RpcbGetAddrList(programNumber, programVersion, netid)
+}
+
+// RpcbGetStat returns the statistics kept by the rpcbind server. If host is
+// empty string, localhost is used.
+func RpcbGetStat(host string) ([]RPCBStat, error) {
+	return (&PmapClient{Host: host}).RpcbGetStat()
+}
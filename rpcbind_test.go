@@ -0,0 +1,157 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+// servePmapV2 serves GETPORT and DUMP of version 2 of the portmapper
+// protocol over TCP on address, answering calls to other versions with
+// ProgMismatch, and returns the address of its listener.
+func servePmapV2(t *testing.T, address string, mappings []PortMapping) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			record, err := ReadFullRecord(conn)
+			if err != nil {
+				return
+			}
+			reader := bytes.NewReader(record)
+			var call RPCMsg
+			if _, err := xdr.Unmarshal(reader, &call); err != nil {
+				return
+			}
+
+			var result interface{}
+			body := ReplyBody{Stat: MsgAccepted, Areply: AcceptedReply{Stat: Success}}
+			switch {
+			case call.CBody.Version != portmapperProgramVersion:
+				body.Areply = AcceptedReply{Stat: ProgMismatch, MismatchInfo: MismatchReply{
+					Low: portmapperProgramVersion, High: portmapperProgramVersion}}
+			case call.CBody.Procedure == 3:
+				var args PortMapping
+				if _, err := xdr.Unmarshal(reader, &args); err != nil {
+					return
+				}
+				var port uint32
+				for _, m := range mappings {
+					if m.Program == args.Program && m.Version == args.Version && m.Protocol == args.Protocol {
+						port = m.Port
+					}
+				}
+				result = port
+			case call.CBody.Procedure == 4:
+				var dump PmapDumpReply
+				for i := len(mappings) - 1; i >= 0; i-- {
+					dump.Next = &portMappingList{Map: mappings[i], Next: dump.Next}
+				}
+				result = &dump
+			default:
+				body.Areply = AcceptedReply{Stat: ProcUnavail}
+			}
+
+			var buf bytes.Buffer
+			if _, err := xdr.Marshal(&buf, &RPCMsg{Xid: call.Xid, Type: Reply, RBody: body}); err != nil {
+				return
+			}
+			if result != nil {
+				if _, err := xdr.Marshal(&buf, result); err != nil {
+					return
+				}
+			}
+			if _, err := WriteFullRecord(conn, buf.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+var pmapV2TestMappings = []PortMapping{
+	{Program: 66613, Version: 1, Protocol: uint32(IPProtoTCP), Port: 2049},
+	{Program: 66613, Version: 1, Protocol: uint32(IPProtoUDP), Port: 2050},
+}
+
+func TestRpcbGetAddrPmapOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		netid   string
+		uaddr   string
+		err     error
+	}{
+		{"127.0.0.1:0", NetidTCP, "127.0.0.1.8.1", nil},
+		{"127.0.0.1:0", NetidUDP, "127.0.0.1.8.2", nil},
+		{"127.0.0.1:0", NetidTCP6, "", ErrNetidUnsupported},
+		{"[::1]:0", NetidTCP6, "::1.8.1", nil},
+		{"[::1]:0", NetidUDP6, "::1.8.2", nil},
+		{"[::1]:0", NetidUDP, "", ErrNetidUnsupported},
+		{"127.0.0.1:0", NetidLocal, "", ErrNetidUnsupported},
+	}
+
+	for _, tc := range tests {
+		client := &PmapClient{Host: servePmapV2(t, tc.address, pmapV2TestMappings)}
+		uaddr, err := client.RpcbGetAddr(66613, 1, tc.netid)
+		if err != tc.err || uaddr != tc.uaddr {
+			t.Errorf("%s %s: got %q, %v, want %q, %v", tc.address, tc.netid, uaddr, err, tc.uaddr, tc.err)
+		}
+	}
+}
+
+func TestRpcbDumpPmapOnly(t *testing.T) {
+	for address, want := range map[string][]RPCB{
+		"127.0.0.1:0": {
+			{Program: 66613, Version: 1, Netid: NetidTCP, Addr: "127.0.0.1.8.1"},
+			{Program: 66613, Version: 1, Netid: NetidUDP, Addr: "127.0.0.1.8.2"},
+		},
+		"[::1]:0": {
+			{Program: 66613, Version: 1, Netid: NetidTCP6, Addr: "::1.8.1"},
+			{Program: 66613, Version: 1, Netid: NetidUDP6, Addr: "::1.8.2"},
+		},
+	} {
+		client := &PmapClient{Host: servePmapV2(t, address, pmapV2TestMappings)}
+		bindings, err := client.RpcbDump()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bindings) != len(want) {
+			t.Fatalf("%s: got %+v, want %+v", address, bindings, want)
+		}
+		for i := range want {
+			if bindings[i] != want[i] {
+				t.Errorf("%s: got %+v, want %+v", address, bindings[i], want[i])
+			}
+		}
+	}
+}
+
+func TestPmapHostIPUnresolved(t *testing.T) {
+	if ip, err := pmapHostIP("host.invalid:111"); err == nil {
+		t.Fatalf("got %s for a host that doesn't resolve", ip)
+	}
+}
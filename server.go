@@ -0,0 +1,190 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Server serves calls to the procedures of a rpc.Server on the connections
+// accepted from listeners, and can be shut down gracefully, like
+// http.Server.
+type Server struct {
+	server      *rpc.Server
+	notifyClose chan<- io.ReadWriteCloser
+	opts        []ServerOption
+
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	codecs    map[*serverCodec]struct{}
+	shutdown  bool
+	conns     sync.WaitGroup // connections being served
+}
+
+// NewServer returns a Server serving calls to the procedures of server,
+// which are looked up in the procedure registry. notifyClose and opts are
+// passed to NewServerCodec for each connection.
+func NewServer(server *rpc.Server, notifyClose chan<- io.ReadWriteCloser, opts ...ServerOption) *Server {
+	return &Server{
+		server:      server,
+		notifyClose: notifyClose,
+		opts:        opts,
+		listeners:   make(map[net.Listener]struct{}),
+		codecs:      make(map[*serverCodec]struct{}),
+	}
+}
+
+// Serve accepts connections on listener and serves calls on them, each in
+// its own goroutine. It returns when accepting fails, or ErrServerClosed
+// once Shutdown or Close is called. The listener is closed when Serve
+// returns.
+func (s *Server) Serve(listener net.Listener) error {
+
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listeners[listener] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.listeners, listener)
+		s.mutex.Unlock()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isShutdown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		codec := NewServerCodec(conn, s.notifyClose, s.opts...).(*serverCodec)
+
+		s.mutex.Lock()
+		if s.shutdown {
+			s.mutex.Unlock()
+			codec.Close()
+			return ErrServerClosed
+		}
+		s.codecs[codec] = struct{}{}
+		s.conns.Add(1)
+		s.mutex.Unlock()
+
+		go s.serveCodec(codec)
+	}
+}
+
+func (s *Server) serveCodec(codec *serverCodec) {
+	defer s.conns.Done()
+
+	// net/rpc waits for the replies to calls in progress before closing
+	// the codec and returning.
+	s.server.ServeCodec(codec)
+
+	s.mutex.Lock()
+	delete(s.codecs, codec)
+	s.mutex.Unlock()
+}
+
+// Shutdown shuts the server down gracefully: it closes the listeners, stops
+// reading calls on the connections and closes each connection once the
+// calls in progress on it are replied to. It returns once all connections
+// are closed, or with the error of ctx if ctx is done first, in which case
+// the remaining connections are closed without waiting for replies.
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	s.mutex.Lock()
+	s.shutdown = true
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	for codec := range s.codecs {
+		codec.drain()
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.closeConns()
+		return ctx.Err()
+	}
+}
+
+// Close closes the listeners and all connections immediately. Calls in
+// progress aren't replied to. See Shutdown for a graceful alternative.
+func (s *Server) Close() error {
+
+	s.mutex.Lock()
+	s.shutdown = true
+	var err error
+	for listener := range s.listeners {
+		if cerr := listener.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.mutex.Unlock()
+
+	s.closeConns()
+	return err
+}
+
+func (s *Server) closeConns() {
+	s.mutex.Lock()
+	codecs := make([]*serverCodec, 0, len(s.codecs))
+	for codec := range s.codecs {
+		codecs = append(codecs, codec)
+	}
+	s.mutex.Unlock()
+
+	// Closing may block on notifyClose, so s.mutex isn't held
+	for _, codec := range codecs {
+		codec.Close()
+	}
+}
+
+func (s *Server) isShutdown() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.shutdown
+}
+
+// drain makes the codec stop reading calls, so that net/rpc closes it once
+// the calls in progress are replied to.
+func (c *serverCodec) drain() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.draining = true
+	if conn, ok := c.conn.(net.Conn); ok {
+		// Wakes up the read in progress, if any
+		_ = conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (c *serverCodec) isDraining() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.draining
+}
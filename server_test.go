@@ -0,0 +1,167 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+var shutdownTestProc = ProcedureID{ProgramNumber: 66628, ProgramVersion: 1, ProcedureNumber: 1}
+
+// ShutdownTest serves calls which take as long as specified.
+type ShutdownTest struct {
+	started chan struct{}
+}
+
+// Sleep replies once the number of milliseconds specified elapsed. Other
+// calls than the first are expected to be instant.
+func (s *ShutdownTest) Sleep(ms int32, reply *int32) error {
+	if ms > 0 {
+		s.started <- struct{}{}
+	}
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+// startShutdownTest serves ShutdownTest with a Server and makes a call
+// taking ms milliseconds with a client connected to it. Once the call is in
+// progress, it returns the Server, the call, the channel Serve returns on
+// and the client.
+func startShutdownTest(t *testing.T, ms int32) (*Server, *rpc.Call, <-chan error, *rpc.Client) {
+	t.Helper()
+
+	if err := RegisterProcedure(Procedure{shutdownTestProc, "ShutdownTest.Sleep"}, true); err != nil {
+		t.Fatal(err)
+	}
+	handler := &ShutdownTest{started: make(chan struct{}, 1)}
+	server := rpc.NewServer()
+	if err := server.Register(handler); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(server, nil)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(listener) }()
+	t.Cleanup(func() { s.Close() })
+
+	client, err := Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	slow := client.Go("ShutdownTest.Sleep", ms, new(int32), nil)
+	select {
+	case <-handler.started:
+	case <-time.After(time.Second):
+		t.Fatal("slow call not started")
+	}
+
+	return s, slow, served, client
+}
+
+// checkServed checks that Serve returned ErrServerClosed.
+func checkServed(t *testing.T, served <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-served:
+		if err != ErrServerClosed {
+			t.Fatalf("Serve returned %v, want %v", err, ErrServerClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	s, slow, served, client := startShutdownTest(t, 300)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// No more calls are read once Serve returned
+	checkServed(t, served)
+	late := client.Go("ShutdownTest.Sleep", int32(0), new(int32), nil)
+
+	// The call in progress is replied to before the connection is closed
+	if call := <-slow.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	if call := <-late.Done; call.Error == nil {
+		t.Fatal("call read after shutdown")
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown didn't return")
+	}
+
+	// Connections aren't accepted afterwards
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(listener); err != ErrServerClosed {
+		t.Fatalf("Serve returned %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	s, slow, served, _ := startShutdownTest(t, 5000)
+
+	// The connection is closed without waiting for the reply once ctx is
+	// done.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Shutdown returned after %v", elapsed)
+	}
+	checkServed(t, served)
+
+	select {
+	case call := <-slow.Done:
+		if call.Error == nil {
+			t.Fatal("slow call replied to")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	s, slow, served, _ := startShutdownTest(t, 5000)
+
+	// The connection is closed without waiting for the reply
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkServed(t, served)
+
+	select {
+	case call := <-slow.Done:
+		if call.Error == nil {
+			t.Fatal("slow call replied to")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+}
@@ -6,9 +6,14 @@ package sunrpc
 
 import (
 	"bytes"
+	"crypto/tls"
 	"io"
 	"log"
+	"net"
 	"net/rpc"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rasky/go-xdr/xdr2"
 )
@@ -18,13 +23,76 @@ type serverCodec struct {
 	closed       bool
 	notifyClose  chan<- io.ReadWriteCloser
 	recordReader io.Reader
+	callInfo     CallInfo        // of the call being read
+	gss          *GSSServer      // accepts RPCSEC_GSS calls, if set
+	authShort    *AuthShortCache // hands out AUTH_SHORT credentials, if set
+
+	// Set if clients may upgrade the connection to TLS
+	tlsConfig   *tls.Config
+	tlsIdentity TLSIdentityFunc
+	tlsRequired bool // calls without TLS are rejected
+	// Set once the connection is upgraded, which replaces conn
+	tlsState *tls.ConnectionState
+	tlsSys   *AuthSysParms
+	origConn io.ReadWriteCloser // sent on notifyClose
+
+	peerCred *PeerCred // of the peer of a Unix domain socket
+
+	// Replies are written by ReadRequestHeader() for calls that never
+	// reach net/rpc, concurrently with WriteResponse().
+	writeMutex sync.Mutex
+
+	mutex   sync.Mutex           // protects pending and the fields below
+	pending map[uint64]replyAuth // authentication of replies by Seq (XID)
+
+	// Limits on the lifetime of the connection. See WithIdleTimeout,
+	// WithMaxLifetime and WithKeepAlive.
+	idleTimeout   time.Duration
+	maxLifetime   time.Duration
+	keepAlive     time.Duration
+	recordTimeout time.Duration
+	minReadRate   int64 // bytes per second
+	idleTimer     *time.Timer
+	lifetimeTimer *time.Timer
+	inProgress    int  // calls read and not replied to yet
+	expired       bool // maxLifetime elapsed
+	draining      bool // no more calls are read; see Server.Shutdown
 }
 
+// ServerOption configures optional behaviour of a server codec.
+type ServerOption func(*serverCodec)
+
 // NewServerCodec returns a new rpc.ServerCodec using Sun RPC on conn.
 // If a non-nil channel is passed as second argument, the conn is sent on
 // that channel when Close() is called on conn.
-func NewServerCodec(conn io.ReadWriteCloser, notifyClose chan<- io.ReadWriteCloser) rpc.ServerCodec {
-	return &serverCodec{conn: conn, notifyClose: notifyClose}
+//
+// Procedure 0 (the null procedure) of every program and version in the
+// procedure registry is answered by the codec itself with an empty reply,
+// unless a procedure is registered for it. The registry is shared by the
+// clients and servers of the process and the codec can't tell which
+// programs are served by the rpc.Server, so this includes programs whose
+// procedures are registered only to call them. Calls to other versions of
+// such programs are likewise answered with ProgMismatch rather than
+// ProgUnavail.
+//
+// If conn is a *net.UnixConn, the credentials of the peer process are
+// passed to procedures in CallInfo.Peer.
+func NewServerCodec(conn io.ReadWriteCloser, notifyClose chan<- io.ReadWriteCloser, opts ...ServerOption) rpc.ServerCodec {
+	c := &serverCodec{
+		conn:        conn,
+		origConn:    conn,
+		notifyClose: notifyClose,
+		pending:     make(map[uint64]replyAuth),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		// The identity of the peer remains unknown on failure
+		c.peerCred, _ = unixPeerCred(unixConn)
+	}
+	c.startLimits()
+	return c
 }
 
 func (c *serverCodec) ReadRequestHeader(req *rpc.Request) error {
@@ -33,44 +101,149 @@ func (c *serverCodec) ReadRequestHeader(req *rpc.Request) error {
 	// as WriteResponse() isn't called. The net/rpc package will call
 	// c.Close() when this function returns an error.
 
-	// Read entire RPC message from network
-	record, err := ReadFullRecord(c.conn)
-	if err != nil {
-		if err != io.EOF {
+	for {
+		if c.isDraining() {
+			return io.EOF
+		}
+
+		// Read entire RPC message from network
+		record, err := ReadFullRecord(c.recordSource())
+		if err != nil {
+			if c.isDraining() {
+				// The server is shutting down
+				return io.EOF
+			}
+			if err != io.EOF && !c.isClosed() {
+				log.Println(err)
+			}
+			return err
+		}
+
+		c.mutex.Lock()
+		c.touch()
+		c.mutex.Unlock()
+
+		reader := bytes.NewReader(record)
+		c.recordReader = reader
+
+		// Unmarshall RPC message
+		var call RPCMsg
+		_, err = xdr.Unmarshal(c.recordReader, &call)
+		if err != nil {
 			log.Println(err)
+			return err
 		}
-		return err
-	}
 
-	c.recordReader = bytes.NewReader(record)
+		if call.Type != Call {
+			log.Println(ErrInvalidRPCMessageType)
+			return ErrInvalidRPCMessageType
+		}
 
-	// Unmarshall RPC message
-	var call RPCMsg
-	_, err = xdr.Unmarshal(c.recordReader, &call)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+		procedureID := ProcedureID{call.CBody.Program, call.CBody.Version, call.CBody.Procedure}
+		c.callInfo = CallInfo{
+			Xid:         call.Xid,
+			ProcedureID: procedureID,
+			Flavor:      call.CBody.Cred.Flavor,
+		}
+		if conn, ok := c.conn.(net.Conn); ok {
+			c.callInfo.RemoteAddr = conn.RemoteAddr()
+		}
+		c.callInfo.TLS = c.tlsState
+		c.callInfo.Peer = c.peerCred
+		c.callInfo.Sys = c.tlsSys
 
-	if call.Type != Call {
-		log.Println(ErrInvalidRPCMessageType)
-		return ErrInvalidRPCMessageType
-	}
+		if call.CBody.Cred.Flavor == AuthTLS {
+			if err := c.startTLS(call.Xid, procedureID); err != nil {
+				return err
+			}
+			continue
+		}
 
-	// Set req.Seq and req.ServiceMethod
-	req.Seq = uint64(call.Xid)
-	procedureID := ProcedureID{call.CBody.Program, call.CBody.Version, call.CBody.Procedure}
-	procedureName, ok := GetProcedureName(procedureID)
-	if ok {
-		req.ServiceMethod = procedureName
-	} else {
-		// Due to our simpler map implementation, we cannot distinguish
-		// between ErrProgUnavail and ErrProcUnavail
-		log.Printf("%s: %+v\n", ErrProcUnavail, procedureID)
-		return ErrProcUnavail
-	}
+		if c.tlsRequired && c.tlsState == nil {
+			reply, err := encodeAuthErrorReply(call.Xid, AuthTooweak)
+			if err != nil {
+				return err
+			}
+			if err := c.writeRecord(reply); err != nil {
+				return err
+			}
+			continue
+		}
 
-	return nil
+		var auth replyAuth
+		if call.CBody.Cred.Flavor == AuthSys || call.CBody.Cred.Flavor == AuthShort {
+			var stat AuthStat
+			if auth, stat = c.acceptSys(call.CBody.Cred); stat != AuthOk {
+				reply, err := encodeAuthErrorReply(call.Xid, stat)
+				if err != nil {
+					return err
+				}
+				if err := c.writeRecord(reply); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if call.CBody.Cred.Flavor == RPCsecGss {
+			var reply []byte
+			if c.gss == nil {
+				reply, err = encodeAuthErrorReply(call.Xid, AuthBadcred)
+			} else {
+				// The header is the call up to and including the
+				// credential, which is followed by the verifier.
+				headerSize := len(record) - reader.Len() - opaqueAuthSize(call.CBody.Verf)
+				var result *gssAcceptResult
+				result, err = c.gss.accept(&call, record[:headerSize], record[len(record)-reader.Len():], c)
+				if err == nil {
+					switch {
+					case result.discard:
+						continue
+					case result.reply != nil:
+						reply = result.reply
+					default:
+						auth = result.call
+						c.recordReader = bytes.NewReader(result.args)
+						c.callInfo.Principal = result.info.Principal
+						c.callInfo.GSSService = result.info.GSSService
+					}
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if reply != nil {
+				if err := c.writeRecord(reply); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		// Set req.Seq and req.ServiceMethod
+		req.Seq = uint64(call.Xid)
+		procedureName, ok := GetProcedureName(procedureID)
+		if ok {
+			req.ServiceMethod = procedureName
+			c.mutex.Lock()
+			if auth != nil {
+				c.pending[req.Seq] = auth
+			}
+			c.callStarted()
+			c.mutex.Unlock()
+			return nil
+		}
+
+		// The call never reaches net/rpc. Reply to it here and move on
+		// to the next call.
+		buf, err := encodeUnregisteredReply(call.Xid, procedureID, auth)
+		if err != nil {
+			return err
+		}
+		if err := c.writeRecord(buf); err != nil {
+			return err
+		}
+	}
 }
 
 func (c *serverCodec) ReadRequestBody(funcArgs interface{}) error {
@@ -80,8 +253,14 @@ func (c *serverCodec) ReadRequestBody(funcArgs interface{}) error {
 	}
 
 	if _, err := xdr.Unmarshal(c.recordReader, &funcArgs); err != nil {
-		c.Close()
-		return err
+		// The record is read in full, so the next call can still be read
+		log.Println(err)
+		return ErrGarbageArgs
+	}
+
+	if receiver, ok := funcArgs.(CallInfoReceiver); ok {
+		info := c.callInfo
+		receiver.SetCallInfo(&info)
 	}
 
 	return nil
@@ -89,56 +268,183 @@ func (c *serverCodec) ReadRequestBody(funcArgs interface{}) error {
 
 func (c *serverCodec) WriteResponse(resp *rpc.Response, result interface{}) error {
 
+	stat := Success
 	if resp.Error != "" {
-		// The remote function returned error (shouldn't really happen)
+		// The remote function returned error
 		log.Println(resp.Error)
+		stat = handlerErrorStat(resp.Error)
+		result = nil
+	}
+
+	c.mutex.Lock()
+	auth := c.pending[resp.Seq]
+	delete(c.pending, resp.Seq)
+	c.mutex.Unlock()
+
+	buf, err := encodeVerifiedReply(uint32(resp.Seq), stat, result, auth)
+	if err != nil {
+		c.Close()
+		return err
 	}
 
+	// Write buffer contents to network
+	err = c.writeRecord(buf)
+
+	c.mutex.Lock()
+	closeNow := c.callDone()
+	c.mutex.Unlock()
+
+	if err != nil || closeNow {
+		c.Close()
+	}
+
+	return err
+}
+
+func (c *serverCodec) writeRecord(buf []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_, err := WriteFullRecord(c.conn, buf)
+	return err
+}
+
+// handlerErrorStat returns the status of the reply to a call for which
+// net/rpc reports the error message specified. Procedures can return
+// ErrProcUnavail, ErrGarbageArgs or ErrSystemErr to reply with the
+// corresponding status. Any other error is a system error.
+func handlerErrorStat(msg string) AcceptStat {
+	switch {
+	case msg == ErrProcUnavail.Error():
+		return ProcUnavail
+	case msg == ErrGarbageArgs.Error():
+		return GarbageArgs
+	case strings.HasPrefix(msg, "rpc: can't find "):
+		// The procedure is in the registry but not served by net/rpc
+		return ProcUnavail
+	}
+	return SystemErr
+}
+
+// encodeReply returns the RPC reply message accepted with the status
+// specified followed by the marshalled procedure-specific result.
+func encodeReply(xid uint32, stat AcceptStat, result interface{}) ([]byte, error) {
+	return encodeVerifiedReply(xid, stat, result, nil)
+}
+
+// encodeVerifiedReply is like encodeReply but the reply carries the
+// verifier of auth and the result is protected by it. auth may be nil.
+func encodeVerifiedReply(xid uint32, stat AcceptStat, result interface{}, auth replyAuth) ([]byte, error) {
+	return encodeAcceptedReply(xid, AcceptedReply{Stat: stat}, result, auth)
+}
+
+func encodeAcceptedReply(xid uint32, areply AcceptedReply, result interface{}, auth replyAuth) ([]byte, error) {
+
 	var buf bytes.Buffer
 
+	if auth != nil {
+		var err error
+		if areply.Verf, err = auth.replyVerifier(); err != nil {
+			return nil, err
+		}
+	}
+
 	reply := RPCMsg{
-		Xid:  uint32(resp.Seq),
+		Xid:  xid,
 		Type: Reply,
 		RBody: ReplyBody{
-			Stat: MsgAccepted,
-			Areply: AcceptedReply{
-				Stat: Success,
-			},
+			Stat:   MsgAccepted,
+			Areply: areply,
 		},
 	}
 
 	if _, err := xdr.Marshal(&buf, reply); err != nil {
-		c.Close()
-		return err
+		return nil, err
 	}
 
 	// Marshal and fill procedure-specific reply into the buffer
-	if _, err := xdr.Marshal(&buf, result); err != nil {
-		c.Close()
-		return err
+	var body bytes.Buffer
+	if result != nil {
+		if _, err := xdr.Marshal(&body, result); err != nil {
+			return nil, err
+		}
 	}
 
-	// Write buffer contents to network
-	if _, err := WriteFullRecord(c.conn, buf.Bytes()); err != nil {
-		c.Close()
-		return err
+	if auth != nil && areply.Stat == Success {
+		wrapped, err := auth.wrapResult(body.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(wrapped)
+	} else {
+		buf.Write(body.Bytes())
 	}
 
-	return nil
+	return buf.Bytes(), nil
+}
+
+/*
+From RFC 5531:
+    By convention, procedure 0 of any remote program is defined to be the
+    "null" procedure that takes no arguments and returns no results.
+*/
+
+// encodeUnregisteredReply returns the RPC reply message to a call of a
+// procedure that isn't in the registry. Procedure 0 of every program and
+// version in the registry is answered with success, unless registered by the
+// user, whether or not the program is served. Otherwise the error returned
+// tells apart an unknown program, an unsupported version of the program and
+// an unknown procedure.
+func encodeUnregisteredReply(xid uint32, procedureID ProcedureID, auth replyAuth) ([]byte, error) {
+
+	areply := AcceptedReply{Stat: ProcUnavail}
+
+	low, high, ok := programVersions(procedureID.ProgramNumber)
+	switch {
+	case !ok:
+		areply.Stat = ProgUnavail
+	case procedureID.ProgramVersion < low || procedureID.ProgramVersion > high:
+		areply.Stat = ProgMismatch
+		areply.MismatchInfo = MismatchReply{low, high}
+	case procedureID.ProcedureNumber == 0 &&
+		hasProgramVersion(procedureID.ProgramNumber, procedureID.ProgramVersion):
+		areply.Stat = Success
+	}
+
+	if areply.Stat != Success {
+		log.Printf("%s: %+v\n", acceptStatErr(areply), procedureID)
+	}
+
+	return encodeAcceptedReply(xid, areply, nil, auth)
 }
 
 func (c *serverCodec) Close() error {
+	c.mutex.Lock()
 	if c.closed {
+		c.mutex.Unlock()
 		return nil
 	}
 
 	err := c.conn.Close()
 	if err == nil {
 		c.closed = true
-		if c.notifyClose != nil {
-			c.notifyClose <- c.conn
-		}
+		c.stopLimits()
+	}
+	c.mutex.Unlock()
+
+	if err == nil && c.gss != nil {
+		c.gss.dropOwner(c)
+	}
+
+	if err == nil && c.notifyClose != nil {
+		c.notifyClose <- c.origConn
 	}
 
 	return err
 }
+
+func (c *serverCodec) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
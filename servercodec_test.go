@@ -0,0 +1,75 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+var nullTestProcs = []Procedure{
+	{ProcedureID{ProgramNumber: 66624, ProgramVersion: 1, ProcedureNumber: 1}, "NullTest.Call"},
+	{ProcedureID{ProgramNumber: 66625, ProgramVersion: 1, ProcedureNumber: 0}, "NullTest.Null"},
+	{ProcedureID{ProgramNumber: 66625, ProgramVersion: 1, ProcedureNumber: 1}, "NullTest.Call"},
+}
+
+// NullTest records the calls to its null procedure.
+type NullTest struct {
+	nulls chan struct{}
+}
+
+func (n *NullTest) Null(args struct{}, reply *struct{}) error {
+	n.nulls <- struct{}{}
+	return nil
+}
+
+func (n *NullTest) Call(args int32, reply *int32) error {
+	*reply = args
+	return nil
+}
+
+func TestNullProcedure(t *testing.T) {
+	for _, p := range nullTestProcs {
+		if err := RegisterProcedure(p, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := &NullTest{make(chan struct{}, 10)}
+	server := rpc.NewServer()
+	if err := server.Register(handler); err != nil {
+		t.Fatal(err)
+	}
+	addr := serveStreamProgram(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Procedure 0 is answered by the codec if it isn't registered
+	if err := Ping(ctx, addr, 66624, 1); err != nil {
+		t.Fatal(err)
+	}
+	// but not for versions that aren't registered
+	if err := Ping(ctx, addr, 66624, 2); !errors.As(err, new(ErrProgMismatch)) {
+		t.Fatalf("got error %v, want %v", err, ErrProgMismatch{Low: 1, High: 1})
+	}
+	select {
+	case <-handler.nulls:
+		t.Fatal("registered null procedure called for another program")
+	default:
+	}
+
+	// A registered procedure 0 is called instead
+	if err := Ping(ctx, addr, 66625, 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handler.nulls:
+	default:
+		t.Fatal("registered null procedure not called")
+	}
+}
@@ -0,0 +1,181 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+/*
+From RFC 9289:
+    To protect RPC traffic to a TCP-based RPC service using TLS, an RPC
+    client first establishes a TCP connection to the RPC service.  The
+    client then sends an RPC NULL procedure to the server using the
+    AUTH_TLS authentication flavor ...  The RPC server signals its
+    corresponding support for RPC-with-TLS by replying with a reply_stat
+    of MSG_ACCEPTED and an AUTH_NONE verifier containing the "STARTTLS"
+    token.  The client SHOULD proceed with TLS session establishment,
+    even if the Reply's accept_stat is not SUCCESS.
+*/
+
+// startTLSVerifier is the body of the verifier of the reply to a probe.
+const startTLSVerifier = "STARTTLS"
+
+// tlsALPN is the ALPN protocol identifier negotiated by RPC-with-TLS peers.
+const tlsALPN = "sunrpc"
+
+// tlsConfig returns a copy of config which negotiates the sunrpc ALPN
+// protocol, as required by RFC 9289.
+func tlsConfig(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	if config == nil {
+		config = new(tls.Config)
+	}
+	config.NextProtos = []string{tlsALPN}
+	return config
+}
+
+// StartTLS upgrades conn, a TCP connection to a server of the program and
+// version specified, to TLS. It probes the server for support of
+// RPC-with-TLS by calling procedure 0 of the program with the AUTH_TLS
+// flavor, which must be the first call made on conn. ErrTLSUnsupported is
+// returned if the server doesn't support RPC-with-TLS, in which case conn
+// can still be used without TLS.
+//
+// Use a config with Certificates set for mutual TLS. The connection
+// returned is then passed to NewClientCodec or NewClient.
+func StartTLS(conn net.Conn, programNumber, programVersion uint32, config *tls.Config) (*tls.Conn, error) {
+
+	xid := rand.Uint32()
+	call := RPCMsg{
+		Xid:  xid,
+		Type: Call,
+		CBody: CallBody{
+			RPCVersion: RPCProtocolVersion,
+			Program:    programNumber,
+			Version:    programVersion,
+			Procedure:  0,
+			Cred:       OpaqueAuth{Flavor: AuthTLS},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, &call); err != nil {
+		return nil, err
+	}
+	if _, err := WriteFullRecord(conn, buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var reply RPCMsg
+	for reply.Xid != xid || reply.Type != Reply {
+		record, err := ReadFullRecord(conn)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := xdr.Unmarshal(bytes.NewReader(record), &reply); err != nil {
+			return nil, err
+		}
+	}
+
+	if reply.RBody.Stat != MsgAccepted {
+		if err := checkReplyForErr(&reply); !errors.Is(err, ErrAuthError) {
+			return nil, err
+		}
+		return nil, ErrTLSUnsupported
+	}
+	verf := reply.RBody.Areply.Verf
+	if verf.Flavor != AuthNone || string(verf.Body) != startTLSVerifier {
+		return nil, ErrTLSUnsupported
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig(config))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// TLSIdentityFunc maps the TLS connection state of a client, which includes
+// its verified certificates, to the identity of the client. The identity is
+// passed to procedures in CallInfo.Sys. Returning an error rejects the
+// client and closes the connection.
+type TLSIdentityFunc func(state *tls.ConnectionState) (*AuthSysParms, error)
+
+// WithTLS makes the server codec accept requests from clients to upgrade the
+// connection to TLS as per RFC 9289. The connection passed to NewServerCodec
+// must be a net.Conn. Set ClientAuth in config to require mutual TLS.
+// identity is optional.
+func WithTLS(config *tls.Config, identity TLSIdentityFunc) ServerOption {
+	return func(c *serverCodec) {
+		c.tlsConfig = tlsConfig(config)
+		c.tlsIdentity = identity
+	}
+}
+
+// WithTLSRequired makes the server codec reject calls made before the
+// connection is upgraded to TLS with AUTH_TOOWEAK, so that procedures are
+// only served over TLS. It's used along with WithTLS.
+func WithTLSRequired() ServerOption {
+	return func(c *serverCodec) {
+		c.tlsRequired = true
+	}
+}
+
+// startTLS replies to the AUTH_TLS probe and, if the server can upgrade the
+// connection, performs the TLS handshake.
+func (c *serverCodec) startTLS(xid uint32, procedureID ProcedureID) error {
+
+	conn, ok := c.conn.(net.Conn)
+	if c.tlsConfig == nil || c.tlsState != nil || !ok || procedureID.ProcedureNumber != 0 {
+		reply, err := encodeAuthErrorReply(xid, AuthBadcred)
+		if err != nil {
+			return err
+		}
+		return c.writeRecord(reply)
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	// The client proceeds with the handshake even if the program isn't
+	// served, which is reported by the status of the reply.
+	reply, err := encodeUnregisteredReply(xid, procedureID,
+		staticReplyAuth{Flavor: AuthNone, Body: []byte(startTLSVerifier)})
+	if err != nil {
+		return err
+	}
+	if _, err := WriteFullRecord(conn, reply); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Server(conn, c.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		log.Println(err)
+		return err
+	}
+	c.mutex.Lock()
+	c.conn = tlsConn
+	c.mutex.Unlock()
+
+	state := tlsConn.ConnectionState()
+	c.tlsState = &state
+	if c.tlsIdentity != nil {
+		if c.tlsSys, err = c.tlsIdentity(&state); err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+var tlsTestProc = ProcedureID{ProgramNumber: 66602, ProgramVersion: 1, ProcedureNumber: 1}
+
+type TLSTestArgs struct {
+	A    int32
+	info *CallInfo
+}
+
+func (a *TLSTestArgs) SetCallInfo(info *CallInfo) { a.info = info }
+
+type TLSTest struct{}
+
+// Who returns the verified and the asserted identity of the caller.
+func (TLSTest) Who(args *TLSTestArgs, reply *string) error {
+	if args.info.TLS == nil {
+		return errors.New("call made without TLS")
+	}
+	*reply = "none"
+	if args.info.Sys != nil {
+		*reply = fmt.Sprint(args.info.Sys.UID)
+	}
+	if args.info.AuthSys != nil {
+		*reply += fmt.Sprintf("/%d", args.info.AuthSys.UID)
+	}
+	return nil
+}
+
+// testCA is a certificate authority generated for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sunrpc test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for name signed by the CA, valid for both
+// servers and clients.
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveTLS serves TLSTest on one end of a pipe, with a certificate for
+// "server" requiring clients to present a certificate, and returns the other
+// end.
+func serveTLS(t *testing.T, ca *testCA, identity TLSIdentityFunc, opts ...ServerOption) net.Conn {
+	t.Helper()
+
+	if err := RegisterProcedure(Procedure{tlsTestProc, "TLSTest.Who"}, true); err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.Register(TLSTest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	opts = append([]ServerOption{WithTLS(config, identity)}, opts...)
+
+	clientConn, serverConn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(serverConn, nil, opts...))
+	t.Cleanup(func() { clientConn.Close() })
+
+	return clientConn
+}
+
+// startTestTLS upgrades conn to TLS with a client certificate for name.
+func startTestTLS(t *testing.T, conn net.Conn, ca *testCA, name string) *tls.Conn {
+	t.Helper()
+
+	tlsConn, err := StartTLS(conn, tlsTestProc.ProgramNumber, tlsTestProc.ProgramVersion, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, name)},
+		RootCAs:      ca.pool,
+		ServerName:   "server",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tlsConn
+}
+
+// uidByName maps the common name of client certificates to a UID.
+func uidByName(uids map[string]uint32) TLSIdentityFunc {
+	return func(state *tls.ConnectionState) (*AuthSysParms, error) {
+		uid, ok := uids[state.PeerCertificates[0].Subject.CommonName]
+		if !ok {
+			return nil, errors.New("unknown client")
+		}
+		return &AuthSysParms{UID: uid, GID: uid}, nil
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	ca := newTestCA(t)
+	conn := startTestTLS(t, serveTLS(t, ca, nil), ca, "alice")
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != tlsALPN {
+		t.Fatalf("negotiated protocol %q, want %q", state.NegotiatedProtocol, tlsALPN)
+	}
+
+	client := NewClient(conn)
+	var who string
+	for i := 0; i < 2; i++ {
+		if err := client.Call("TLSTest.Who", &TLSTestArgs{}, &who); err != nil {
+			t.Fatal(err)
+		}
+		if who != "none" {
+			t.Fatalf("got identity %q without TLSIdentityFunc", who)
+		}
+	}
+}
+
+func TestTLSIdentityFunc(t *testing.T) {
+	ca := newTestCA(t)
+	identity := uidByName(map[string]uint32{"alice": 1000})
+	conn := startTestTLS(t, serveTLS(t, ca, identity), ca, "alice")
+
+	// The asserted credential is passed apart from the verified identity
+	auth, err := NewSysClientAuth(&AuthSysParms{UID: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClientWithCodec(NewClientCodec(conn, nil, WithAuth(auth)))
+
+	var who string
+	if err := client.Call("TLSTest.Who", &TLSTestArgs{}, &who); err != nil {
+		t.Fatal(err)
+	}
+	if who != "1000/0" {
+		t.Fatalf("got identity %q, want 1000/0", who)
+	}
+}
+
+func TestTLSIdentityFuncRejects(t *testing.T) {
+	ca := newTestCA(t)
+	identity := uidByName(map[string]uint32{"alice": 1000})
+	conn := startTestTLS(t, serveTLS(t, ca, identity), ca, "mallory")
+
+	// The server closes the connection after the handshake
+	var who string
+	if err := NewClient(conn).Call("TLSTest.Who", &TLSTestArgs{}, &who); err == nil {
+		t.Fatalf("call by unknown client succeeded with %q", who)
+	}
+}
+
+func TestStartTLSUnsupported(t *testing.T) {
+	if err := RegisterProcedure(Procedure{tlsTestProc, "TLSTest.Who"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go rpc.NewServer().ServeCodec(NewServerCodec(serverConn, nil))
+
+	_, err := StartTLS(clientConn, tlsTestProc.ProgramNumber, tlsTestProc.ProgramVersion, &tls.Config{})
+	if err != ErrTLSUnsupported {
+		t.Fatalf("got error %v, want %v", err, ErrTLSUnsupported)
+	}
+}
+
+func TestTLSRequired(t *testing.T) {
+	ca := newTestCA(t)
+	identity := uidByName(map[string]uint32{"alice": 1000})
+
+	// A plaintext client is refused
+	client := WrapClient(NewClient(serveTLS(t, ca, identity, WithTLSRequired())))
+	var who string
+	err := client.Call("TLSTest.Who", &TLSTestArgs{}, &who)
+	var rejected ErrAuthRejected
+	if !errors.As(err, &rejected) || rejected.Stat != AuthTooweak {
+		t.Fatalf("got error %v, want AUTH_TOOWEAK", err)
+	}
+
+	// and can still upgrade the connection
+	conn := startTestTLS(t, serveTLS(t, ca, identity, WithTLSRequired()), ca, "alice")
+	if err := NewClient(conn).Call("TLSTest.Who", &TLSTestArgs{}, &who); err != nil {
+		t.Fatal(err)
+	}
+	if who != "1000" {
+		t.Fatalf("got identity %q, want 1000", who)
+	}
+}
@@ -0,0 +1,244 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+From RFC 5665 (https://tools.ietf.org/html/rfc5665)
+
+   The universal address of an IPv4 or IPv6 endpoint is the string
+   representation of the IP address followed by the port number as two
+   decimal octets, all separated by dots:
+
+      h1.h2.h3.h4.p1.p2      (IPv4)
+      x1:x2:...:x8.p1.p2     (IPv6)
+
+   where the port number is p1 * 256 + p2. The netid identifies the
+   transport over which the universal address is to be interpreted.
+*/
+
+// Network identifiers (netids) as registered by RFC 5665
+const (
+	NetidTCP   = "tcp"   // TCP over IPv4
+	NetidUDP   = "udp"   // UDP over IPv4
+	NetidTCP6  = "tcp6"  // TCP over IPv6
+	NetidUDP6  = "udp6"  // UDP over IPv6
+	NetidLocal = "local" // Unix domain (AF_LOCAL) stream socket
+)
+
+// NetidToProtocol returns the Protocol used by the portmapper (version 2)
+// for the netid specified. Only "tcp" and "udp" have such a mapping.
+func NetidToProtocol(netid string) (Protocol, error) {
+	switch netid {
+	case NetidTCP:
+		return IPProtoTCP, nil
+	case NetidUDP:
+		return IPProtoUDP, nil
+	}
+	return 0, ErrNetidUnsupported
+}
+
+// ProtocolToNetid returns the IPv4 netid for the Protocol specified. An
+// empty string is returned if the protocol is unknown.
+func ProtocolToNetid(protocol Protocol) string {
+	switch protocol {
+	case IPProtoTCP:
+		return NetidTCP
+	case IPProtoUDP:
+		return NetidUDP
+	}
+	return ""
+}
+
+// NetidNetwork returns the name of the network, as used by package net, for
+// the netid specified.
+func NetidNetwork(netid string) (string, error) {
+	switch netid {
+	case NetidTCP:
+		return "tcp4", nil
+	case NetidUDP:
+		return "udp4", nil
+	case NetidTCP6:
+		return "tcp6", nil
+	case NetidUDP6:
+		return "udp6", nil
+	case NetidLocal:
+		return "unix", nil
+	}
+	return "", ErrNetidUnsupported
+}
+
+func parseUaddrOctet(s string) (int, bool) {
+	// Strictly decimal digits, no sign and no superfluous leading zeros
+	if len(s) == 0 || len(s) > 3 || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n > 255 {
+		return 0, false
+	}
+	return n, true
+}
+
+// splitUaddr splits an IP universal address into the host part and port.
+func splitUaddr(uaddr string) (string, int, error) {
+	i := strings.LastIndexByte(uaddr, '.')
+	if i < 0 {
+		return "", 0, ErrInvalidUniversalAddress
+	}
+	j := strings.LastIndexByte(uaddr[:i], '.')
+	if j <= 0 {
+		return "", 0, ErrInvalidUniversalAddress
+	}
+
+	p1, ok := parseUaddrOctet(uaddr[j+1 : i])
+	if !ok {
+		return "", 0, ErrInvalidUniversalAddress
+	}
+	p2, ok := parseUaddrOctet(uaddr[i+1:])
+	if !ok {
+		return "", 0, ErrInvalidUniversalAddress
+	}
+
+	return uaddr[:j], p1<<8 | p2, nil
+}
+
+// ParseUniversalAddress converts a universal address of the transport
+// identified by netid into a net.Addr. The address returned is a
+// *net.TCPAddr, *net.UDPAddr or *net.UnixAddr depending on the netid.
+// IPv4-mapped IPv6 addresses are rejected under the IPv6 netids, so that
+// the addresses returned are formatted back by FormatUniversalAddress
+// unchanged.
+func ParseUniversalAddress(netid, uaddr string) (net.Addr, error) {
+
+	if netid == NetidLocal {
+		if uaddr == "" || strings.IndexByte(uaddr, 0) >= 0 {
+			return nil, ErrInvalidUniversalAddress
+		}
+		return &net.UnixAddr{Name: uaddr, Net: "unix"}, nil
+	}
+
+	host, port, err := splitUaddr(uaddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var zone string
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host, zone = host[:i], host[i+1:]
+		if zone == "" {
+			return nil, ErrInvalidUniversalAddress
+		}
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, ErrInvalidUniversalAddress
+	}
+
+	switch netid {
+	case NetidTCP, NetidUDP:
+		// IPv4 address in dotted-decimal notation only
+		if ip.To4() == nil || strings.IndexByte(host, ':') >= 0 || zone != "" {
+			return nil, ErrInvalidUniversalAddress
+		}
+		ip = ip.To4()
+	case NetidTCP6, NetidUDP6:
+		// IPv4-mapped addresses are IPv4 addresses to package net, which
+		// would format them with the IPv4 netids
+		if strings.IndexByte(host, ':') < 0 || ip.To4() != nil {
+			return nil, ErrInvalidUniversalAddress
+		}
+	default:
+		return nil, ErrNetidUnsupported
+	}
+
+	switch netid {
+	case NetidTCP, NetidTCP6:
+		return &net.TCPAddr{IP: ip, Port: port, Zone: zone}, nil
+	default:
+		return &net.UDPAddr{IP: ip, Port: port, Zone: zone}, nil
+	}
+}
+
+// FormatUniversalAddress converts addr into a netid and universal address
+// pair. The addr must be a *net.TCPAddr, *net.UDPAddr or *net.UnixAddr. IPv4
+// and IPv4-mapped IPv6 addresses are formatted with the IPv4 netids.
+func FormatUniversalAddress(addr net.Addr) (string, string, error) {
+
+	var ip net.IP
+	var port int
+	var zone string
+	var netid, netid6 string
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port, zone = a.IP, a.Port, a.Zone
+		netid, netid6 = NetidTCP, NetidTCP6
+	case *net.UDPAddr:
+		ip, port, zone = a.IP, a.Port, a.Zone
+		netid, netid6 = NetidUDP, NetidUDP6
+	case *net.UnixAddr:
+		if a.Net != "unix" || a.Name == "" || strings.IndexByte(a.Name, 0) >= 0 {
+			return "", "", ErrInvalidUniversalAddress
+		}
+		return NetidLocal, a.Name, nil
+	default:
+		return "", "", ErrNetidUnsupported
+	}
+
+	if port < 0 || port > 0xffff {
+		return "", "", ErrInvalidUniversalAddress
+	}
+
+	if len(ip) == 0 {
+		ip = net.IPv4zero
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return netid, fmt.Sprintf("%s.%d.%d", ip4.String(), port>>8, port&0xff), nil
+	}
+
+	if len(ip) != net.IPv6len {
+		return "", "", ErrInvalidUniversalAddress
+	}
+
+	host := ip.String()
+	if zone != "" {
+		host += "%" + zone
+	}
+
+	return netid6, fmt.Sprintf("%s.%d.%d", host, port>>8, port&0xff), nil
+}
+
+// protocolAddr returns the net.Addr of the IP endpoint for the protocol.
+func protocolAddr(protocol Protocol, ip net.IP, port int) net.Addr {
+	if protocol == IPProtoUDP {
+		return &net.UDPAddr{IP: ip, Port: port}
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+// addrPort returns the port number of an IP endpoint and zero otherwise.
+func addrPort(addr net.Addr) int {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.Port
+	case *net.UDPAddr:
+		return a.Port
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseUniversalAddress(t *testing.T) {
+	tests := []struct {
+		netid string
+		uaddr string
+		addr  net.Addr // nil if rejected with err
+		err   error
+	}{
+		{NetidTCP, "192.0.2.1.0.111", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 111}, nil},
+		{NetidUDP, "192.0.2.1.8.1", &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 2049}, nil},
+		{NetidTCP, "0.0.0.0.0.0", &net.TCPAddr{IP: net.IPv4zero.To4(), Port: 0}, nil},
+		{NetidTCP, "192.0.2.1.255.255", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 65535}, nil},
+		{NetidTCP6, "::1.0.111", &net.TCPAddr{IP: net.IPv6loopback, Port: 111}, nil},
+		{NetidUDP6, "fe80::1%eth0.8.1", &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 2049, Zone: "eth0"}, nil},
+		{NetidLocal, "/var/run/rpcbind.sock", &net.UnixAddr{Name: "/var/run/rpcbind.sock", Net: "unix"}, nil},
+
+		{NetidTCP, "192.0.2.1.256.0", nil, ErrInvalidUniversalAddress},
+		{NetidTCP, "192.0.2.1.0.256", nil, ErrInvalidUniversalAddress},
+		{NetidTCP, "192.0.2.1.00.1", nil, ErrInvalidUniversalAddress},
+		{NetidTCP, "192.0.2.1.+1.1", nil, ErrInvalidUniversalAddress},
+		{NetidTCP, "192.0.2.1.1", nil, ErrInvalidUniversalAddress},
+		{NetidTCP, ".0.111", nil, ErrInvalidUniversalAddress},
+		{NetidTCP, "::1.0.111", nil, ErrInvalidUniversalAddress},
+		{NetidTCP, "192.0.2.1%eth0.0.111", nil, ErrInvalidUniversalAddress},
+		{NetidTCP6, "192.0.2.1.0.111", nil, ErrInvalidUniversalAddress},
+		{NetidTCP6, "fe80::1%.0.111", nil, ErrInvalidUniversalAddress},
+		{NetidTCP, "::ffff:192.0.2.1.0.111", nil, ErrInvalidUniversalAddress},
+		{NetidTCP6, "::ffff:192.0.2.1.0.111", nil, ErrInvalidUniversalAddress},
+		{NetidUDP6, "::ffff:c000:201.0.111", nil, ErrInvalidUniversalAddress},
+		{NetidLocal, "", nil, ErrInvalidUniversalAddress},
+		{NetidLocal, "/tmp/a\x00b", nil, ErrInvalidUniversalAddress},
+		{"sctp", "192.0.2.1.0.111", nil, ErrNetidUnsupported},
+	}
+
+	for _, tc := range tests {
+		addr, err := ParseUniversalAddress(tc.netid, tc.uaddr)
+		if err != tc.err {
+			t.Errorf("%s %q: got error %v, want %v", tc.netid, tc.uaddr, err, tc.err)
+			continue
+		}
+		if tc.addr == nil {
+			continue
+		}
+		if addr.Network() != tc.addr.Network() || addr.String() != tc.addr.String() {
+			t.Errorf("%s %q: got %s %s, want %s %s", tc.netid, tc.uaddr,
+				addr.Network(), addr, tc.addr.Network(), tc.addr)
+		}
+
+		// Every address accepted is formatted back unchanged
+		netid, uaddr, err := FormatUniversalAddress(addr)
+		if err != nil || netid != tc.netid || uaddr != tc.uaddr {
+			t.Errorf("%s %q: formatted as %s %q, %v", tc.netid, tc.uaddr, netid, uaddr, err)
+		}
+	}
+}
+
+func TestFormatUniversalAddress(t *testing.T) {
+	tests := []struct {
+		addr  net.Addr
+		netid string
+		uaddr string
+		err   error
+	}{
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 111}, NetidTCP, "192.0.2.1.0.111", nil},
+		{&net.UDPAddr{Port: 65535}, NetidUDP, "0.0.0.0.255.255", nil},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 0}, NetidTCP, "192.0.2.1.0.0", nil},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 2049, Zone: "eth0"}, NetidUDP6, "fe80::1%eth0.8.1", nil},
+		{&net.UnixAddr{Name: "/var/run/rpcbind.sock", Net: "unix"}, NetidLocal, "/var/run/rpcbind.sock", nil},
+
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 65536}, "", "", ErrInvalidUniversalAddress},
+		{&net.TCPAddr{IP: net.IP{1, 2, 3}, Port: 1}, "", "", ErrInvalidUniversalAddress},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unixgram"}, "", "", ErrInvalidUniversalAddress},
+		{&net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}, "", "", ErrNetidUnsupported},
+	}
+
+	for _, tc := range tests {
+		netid, uaddr, err := FormatUniversalAddress(tc.addr)
+		if err != tc.err || netid != tc.netid || uaddr != tc.uaddr {
+			t.Errorf("%s: got %s %q %v, want %s %q %v", tc.addr,
+				netid, uaddr, err, tc.netid, tc.uaddr, tc.err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+const (
+	// Default total time to wait for a reply to a call made over UDP
+	defaultUDPTimeout = 25 * time.Second
+	// Default time to wait for a reply before retransmitting the call
+	defaultUDPRetransmit = 5 * time.Second
+	// Upper limit for the retransmission interval which is doubled after
+	// each retransmission
+	maxUDPRetransmit = 30 * time.Second
+)
+
+// udpCall is a call awaiting reply on a datagram transport
+type udpCall struct {
+	serviceMethod string
+	datagram      []byte        // call message to (re)send
+	deadline      time.Time     // when to give up waiting for reply
+	resend        time.Time     // when to retransmit next
+	interval      time.Duration // current retransmission interval
+	state         *callState
+}
+
+type udpClientCodec struct {
+	conn         net.Conn  // connected datagram socket
+	recordReader io.Reader // reader for RPC reply
+	buf          []byte    // buffer for incoming datagrams
+
+	timeout    time.Duration
+	retransmit time.Duration
+
+	mutex   sync.Mutex          // protects pending
+	pending map[uint64]*udpCall // maps Seq (XID) to call
+
+	// unreachable, if set, is called if a call times out or the
+	// connection is refused before any reply arrives, which suggests that
+	// the program doesn't listen on the port. See DialProgram.
+	unreachable func()
+	replied     bool
+}
+
+// NewUDPClientCodec returns a new rpc.ClientCodec using Sun RPC over the
+// datagram conn. Unlike on stream transports, there is no record marking and
+// a call is retransmitted if a reply doesn't arrive within the retransmit
+// interval, which is doubled on every retransmission. If no reply arrives
+// within timeout, the call fails with ErrTimeout (see Client). Zero
+// values for timeout and retransmit select the defaults of 25 and 5 seconds
+// respectively. Calls failed by the server fail as with NewClientCodec.
+func NewUDPClientCodec(conn net.Conn, timeout, retransmit time.Duration) rpc.ClientCodec {
+	if timeout <= 0 {
+		timeout = defaultUDPTimeout
+	}
+	if retransmit <= 0 {
+		retransmit = defaultUDPRetransmit
+	}
+
+	return &udpClientCodec{
+		conn:       conn,
+		buf:        make([]byte, maxRecordSize),
+		timeout:    timeout,
+		retransmit: retransmit,
+		pending:    make(map[uint64]*udpCall),
+	}
+}
+
+// NewUDPClient returns a new rpc.Client which internally uses Sun RPC codec
+// over the datagram conn.
+func NewUDPClient(conn net.Conn, timeout, retransmit time.Duration) *rpc.Client {
+	return rpc.NewClientWithCodec(NewUDPClientCodec(conn, timeout, retransmit))
+}
+
+func (c *udpClientCodec) WriteRequest(req *rpc.Request, param interface{}) error {
+
+	procedureID, ok := GetProcedureID(req.ServiceMethod)
+	if !ok {
+		return ErrProcUnavail
+	}
+
+	var state *callState
+	if s, ok := param.(*callState); ok {
+		state = s
+		param = s.args
+	}
+
+	datagram, err := encodeCall(uint32(req.Seq), procedureID, param)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	c.mutex.Lock()
+	c.pending[req.Seq] = &udpCall{
+		serviceMethod: req.ServiceMethod,
+		datagram:      datagram,
+		deadline:      now.Add(c.timeout),
+		resend:        now.Add(c.retransmit),
+		interval:      c.retransmit,
+		state:         state,
+	}
+	c.mutex.Unlock()
+
+	_, err = c.conn.Write(datagram)
+	return err
+}
+
+// expired removes and returns a call that has timed out, if any.
+func (c *udpClientCodec) expired(now time.Time) (uint64, *udpCall, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for seq, call := range c.pending {
+		if !now.Before(call.deadline) {
+			delete(c.pending, seq)
+			return seq, call, true
+		}
+	}
+
+	return 0, nil, false
+}
+
+// resendDue retransmits calls whose retransmission interval has elapsed and
+// returns the time at which the codec has to wake up next.
+func (c *udpClientCodec) resendDue(now time.Time) time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	wakeup := now.Add(c.retransmit)
+	for _, call := range c.pending {
+		if !now.Before(call.resend) {
+			_, _ = c.conn.Write(call.datagram)
+			call.interval *= 2
+			if call.interval > maxUDPRetransmit {
+				call.interval = maxUDPRetransmit
+			}
+			call.resend = now.Add(call.interval)
+		}
+		if call.resend.Before(wakeup) {
+			wakeup = call.resend
+		}
+		if call.deadline.Before(wakeup) {
+			wakeup = call.deadline
+		}
+	}
+
+	return wakeup
+}
+
+func (c *udpClientCodec) ReadResponseHeader(resp *rpc.Response) error {
+
+	c.recordReader = nil
+	for {
+		now := time.Now()
+
+		// net/rpc has no notion of timeouts. A call that timed out is
+		// completed with an error and the client remains usable.
+		if seq, call, ok := c.expired(now); ok {
+			c.noReply()
+			resp.Seq = seq
+			resp.ServiceMethod = call.serviceMethod
+			failCall(resp, call.state, ErrTimeout)
+			return nil
+		}
+
+		if err := c.conn.SetReadDeadline(c.resendDue(now)); err != nil {
+			return err
+		}
+
+		n, err := c.conn.Read(c.buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				c.noReply()
+			}
+			return err
+		}
+
+		// Unmarshal datagram as RPC reply
+		reader := bytes.NewReader(c.buf[:n])
+		var reply RPCMsg
+		if _, err := xdr.Unmarshal(reader, &reply); err != nil || reply.Type != Reply {
+			// Not a reply, drop it
+			continue
+		}
+
+		seq := uint64(reply.Xid)
+		c.mutex.Lock()
+		call, ok := c.pending[seq]
+		delete(c.pending, seq)
+		c.mutex.Unlock()
+		if !ok {
+			// Duplicate reply to a retransmitted call
+			continue
+		}
+
+		c.replied = true
+		resp.Seq = seq
+		resp.ServiceMethod = call.serviceMethod
+
+		procedureID, _ := GetProcedureID(call.serviceMethod)
+		if err := replyError(&reply, procedureID, c.conn.RemoteAddr()); err != nil {
+			if rerr, ok := err.(*RPCError); ok {
+				failCall(resp, call.state, rerr)
+				return nil
+			}
+			return err
+		}
+
+		c.recordReader = reader
+		return nil
+	}
+}
+
+// noReply calls unreachable if no reply has arrived yet.
+func (c *udpClientCodec) noReply() {
+	if c.unreachable != nil && !c.replied {
+		c.unreachable()
+		c.unreachable = nil
+	}
+}
+
+func (c *udpClientCodec) ReadResponseBody(result interface{}) error {
+
+	if result == nil || c.recordReader == nil {
+		return nil
+	}
+
+	if _, err := xdr.Unmarshal(c.recordReader, &result); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *udpClientCodec) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,107 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sunrpc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+// errNoReply can be returned by a procedure served over a datagram transport
+// to suppress the reply altogether. This is required of the portmapper's
+// CALLIT procedure when the remote procedure fails, so that broadcast calls
+// don't get flooded with errors.
+var errNoReply = errors.New("No reply to be sent")
+
+// datagramServerCodec is a rpc.ServerCodec that serves a single call that
+// arrived as a datagram, to be used with rpc.Server.ServeRequest().
+type datagramServerCodec struct {
+	conn         net.PacketConn
+	addr         net.Addr // address of the caller
+	datagram     []byte
+	xid          uint32
+	recordReader io.Reader
+}
+
+func newDatagramServerCodec(conn net.PacketConn, addr net.Addr, datagram []byte) *datagramServerCodec {
+	return &datagramServerCodec{conn: conn, addr: addr, datagram: datagram}
+}
+
+func (c *datagramServerCodec) ReadRequestHeader(req *rpc.Request) error {
+
+	c.recordReader = bytes.NewReader(c.datagram)
+
+	var call RPCMsg
+	if _, err := xdr.Unmarshal(c.recordReader, &call); err != nil {
+		return err
+	}
+
+	if call.Type != Call {
+		return ErrInvalidRPCMessageType
+	}
+
+	c.xid = call.Xid
+	req.Seq = uint64(call.Xid)
+	procedureID := ProcedureID{call.CBody.Program, call.CBody.Version, call.CBody.Procedure}
+	procedureName, ok := GetProcedureName(procedureID)
+	if !ok {
+		if buf, err := encodeUnregisteredReply(call.Xid, procedureID, nil); err == nil {
+			_, _ = c.conn.WriteTo(buf, c.addr)
+		}
+		return ErrProcUnavail
+	}
+	req.ServiceMethod = procedureName
+
+	return nil
+}
+
+func (c *datagramServerCodec) ReadRequestBody(funcArgs interface{}) error {
+
+	if funcArgs == nil {
+		return nil
+	}
+
+	if _, err := xdr.Unmarshal(c.recordReader, &funcArgs); err != nil {
+		log.Println(err)
+		return ErrGarbageArgs
+	}
+
+	return nil
+}
+
+func (c *datagramServerCodec) WriteResponse(resp *rpc.Response, result interface{}) error {
+
+	switch resp.Error {
+	case "":
+		return c.writeReply(Success, result)
+	case errNoReply.Error():
+		return nil
+	default:
+		log.Println(resp.Error)
+		return c.writeReply(handlerErrorStat(resp.Error), nil)
+	}
+}
+
+func (c *datagramServerCodec) writeReply(stat AcceptStat, result interface{}) error {
+
+	buf, err := encodeReply(c.xid, stat, result)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.WriteTo(buf, c.addr)
+	return err
+}
+
+func (c *datagramServerCodec) Close() error {
+	// The datagram conn is shared by all calls and not owned by the codec
+	return nil
+}